@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"sys-design/deployments/migrations"
+	"sys-design/internal/admission"
 	"sys-design/internal/api"
 	"sys-design/internal/config"
 	"sys-design/internal/db"
-	"sys-design/internal/mq"
+	"sys-design/internal/events"
+	"sys-design/internal/fetch"
+	"sys-design/internal/janitor"
+	"sys-design/internal/migrate"
 	"sys-design/internal/obs"
 	"sys-design/internal/storage"
 )
@@ -28,29 +34,89 @@ func main() {
 	}
 	defer pool.Close()
 
+	if cfg.MigrateOnStart {
+		all, err := migrate.Load(migrations.FS)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := migrate.Up(ctx, pool, all); err != nil {
+			panic(err)
+		}
+	}
+
 	store, err := storage.NewMinioStore(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	publisher, err := mq.NewPublisher(cfg)
+	obs.RegisterAll()
+
+	j := &janitor.Janitor{
+		DB:             pool,
+		Store:          store,
+		UploadTTL:      time.Duration(cfg.UploadTTLSeconds) * time.Second,
+		IdempotencyTTL: time.Duration(cfg.IdempotencyTTLSeconds) * time.Second,
+		Interval:       time.Duration(cfg.JanitorIntervalSeconds) * time.Second,
+		BatchSize:      cfg.JanitorBatchSize,
+	}
+	go j.Run(db.WithActor(ctx, "janitor"))
+
+	adm := &admission.Controller{
+		DB:         pool,
+		MaxBacklog: cfg.AdmissionMaxBacklog,
+		Interval:   time.Duration(cfg.AdmissionIntervalSeconds) * time.Second,
+	}
+	go adm.Run(ctx)
+
+	hub := events.NewHub(pool)
+	go hub.Run(ctx)
+
+	callbackAllow, err := fetch.ParseCIDRs(strings.Split(cfg.WebhookAllowCIDRs, ","))
 	if err != nil {
 		panic(err)
 	}
-	defer publisher.Close()
 
-	obs.RegisterAll()
+	writeTimeout := 10 * time.Second
 
 	r := gin.Default()
+	// Without this gin believes X-Forwarded-For from any peer, and clients
+	// could pick the IP they are rate limited under.
+	var trustedProxies []string
+	for _, p := range strings.Split(cfg.TrustedProxies, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		panic(err)
+	}
 	r.Use(api.MetricsMiddleware())
-	srv := &api.Server{DB: pool, Store: store, Publisher: publisher}
+	srv := &api.Server{
+		DB:          pool,
+		Store:       store,
+		Events:      hub,
+		DeleteGrace: time.Duration(cfg.DeleteGraceSeconds) * time.Second,
+		MaxWait:     writeTimeout - 2*time.Second,
+
+		DefaultStorageQuota: cfg.TenantStorageQuotaBytes,
+		DefaultMonthlyQuota: cfg.TenantMonthlyProcessingQuota,
+		RateLimiter: api.NewRateLimiter(map[string]api.RateLimit{
+			"ip":        {Rate: cfg.RateLimitIPRPS, Burst: cfg.RateLimitIPBurst},
+			"default":   {Rate: cfg.RateLimitDefaultRPS, Burst: cfg.RateLimitDefaultBurst},
+			"presign":   {Rate: cfg.RateLimitPresignRPS, Burst: cfg.RateLimitPresignBurst},
+			"reprocess": {Rate: cfg.RateLimitReprocessRPS, Burst: cfg.RateLimitReprocessBurst},
+		}),
+		Admission: adm,
+
+		CallbackAllowCIDRs: callbackAllow,
+	}
 	srv.RegisterRoutes(r)
 
 	s := &http.Server{
 		Addr:           ":" + cfg.APIPort,
 		Handler:        r,
 		ReadTimeout:    5 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		WriteTimeout:   writeTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oklog/ulid/v2"
+
+	"sys-design/internal/api"
+	"sys-design/internal/config"
+	"sys-design/internal/db"
+)
+
+// apikey creates or revokes API keys:
+//
+//	go run ./cmd/apikey -owner alice -name laptop
+//	go run ./cmd/apikey -revoke <key_id>
+func main() {
+	owner := flag.String("owner", "", "owner of the new key")
+	name := flag.String("name", "", "label for the new key")
+	revoke := flag.String("revoke", "", "ID of a key to revoke")
+	flag.Parse()
+
+	if (*owner == "") == (*revoke == "") {
+		fmt.Fprintln(os.Stderr, "usage: apikey -owner <owner> [-name <name>] | -revoke <key_id>")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.Connect(ctx, cfg.PostgresDSN())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	if *revoke != "" {
+		revoked, err := db.RevokeAPIKey(ctx, pool, *revoke)
+		if err != nil {
+			panic(err)
+		}
+		if !revoked {
+			fmt.Fprintln(os.Stderr, "no active key with that ID")
+			os.Exit(1)
+		}
+		fmt.Println("revoked", *revoke)
+		return
+	}
+
+	if err := db.EnsureTenant(ctx, pool, *owner); err != nil {
+		panic(err)
+	}
+
+	key, hash, err := api.NewAPIKey()
+	if err != nil {
+		panic(err)
+	}
+	id := ulid.Make().String()
+	if err := db.InsertAPIKey(ctx, pool, id, *owner, *name, hash); err != nil {
+		panic(err)
+	}
+
+	// The plaintext key is not stored anywhere; this is the only time it is shown.
+	fmt.Println("key_id:", id)
+	fmt.Println("api_key:", key)
+}
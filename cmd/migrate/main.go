@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"sys-design/deployments/migrations"
+	"sys-design/internal/config"
+	"sys-design/internal/db"
+	"sys-design/internal/migrate"
+)
+
+// migrate applies, reverts or lists the schema migrations:
+//
+//	go run ./cmd/migrate            # apply pending migrations
+//	go run ./cmd/migrate -down 1    # revert the latest migration
+//	go run ./cmd/migrate -status
+func main() {
+	down := flag.Int("down", 0, "number of migrations to revert")
+	status := flag.Bool("status", false, "list migrations and when they were applied")
+	flag.Parse()
+
+	if *down < 0 || (*down > 0 && *status) {
+		fmt.Fprintln(os.Stderr, "usage: migrate [-down <n> | -status]")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.Connect(ctx, cfg.PostgresDSN())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		panic(err)
+	}
+
+	switch {
+	case *status:
+		statuses, err := migrate.Statuses(ctx, pool, all)
+		if err != nil {
+			panic(err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05Z07:00")
+			}
+			fmt.Printf("%s\t%s\n", s.Migration, applied)
+		}
+	case *down > 0:
+		reverted, err := migrate.Down(ctx, pool, all, *down)
+		fmt.Printf("reverted %d migration(s)\n", len(reverted))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		applied, err := migrate.Up(ctx, pool, all)
+		fmt.Printf("applied %d migration(s)\n", len(applied))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
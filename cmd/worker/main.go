@@ -2,23 +2,38 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"sys-design/internal/config"
 	"sys-design/internal/db"
+	"sys-design/internal/fetch"
 	"sys-design/internal/mq"
 	"sys-design/internal/obs"
+	"sys-design/internal/pipeline"
+	"sys-design/internal/scheduler"
 	"sys-design/internal/storage"
+	"sys-design/internal/sweeper"
+	"sys-design/internal/webhook"
 )
 
 func main() {
+	stepsFlag := flag.String("steps", strings.Join(pipeline.AllSteps, ","), "comma-separated steps to consume")
+	flag.Parse()
+
+	steps, err := parseSteps(*stepsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		panic(err)
@@ -36,6 +51,12 @@ func main() {
 		panic(err)
 	}
 
+	publisher, err := mq.NewPublisher(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer publisher.Close()
+
 	obs.RegisterAll()
 	go func() {
 		mux := http.NewServeMux()
@@ -44,6 +65,43 @@ func main() {
 		_ = http.ListenAndServe(":"+cfg.WorkerMetricsPort, mux)
 	}()
 
+	if cfg.WebhookSecret == "" {
+		panic("WEBHOOK_SECRET must be set")
+	}
+	webhookAllow, err := fetch.ParseCIDRs(strings.Split(cfg.WebhookAllowCIDRs, ","))
+	if err != nil {
+		panic(err)
+	}
+	dispatcher := &webhook.Dispatcher{
+		DB:          pool,
+		Client:      webhook.NewClient(time.Duration(cfg.WebhookTimeoutSeconds)*time.Second, webhookAllow),
+		Secret:      []byte(cfg.WebhookSecret),
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		BaseBackoff: 5 * time.Second,
+		MaxBackoff:  time.Hour,
+		Interval:    time.Duration(cfg.WebhookIntervalSeconds) * time.Second,
+		BatchSize:   50,
+	}
+	go dispatcher.Run(ctx)
+
+	sw := &sweeper.Sweeper{
+		DB:              pool,
+		Interval:        time.Duration(cfg.SweeperIntervalSeconds) * time.Second,
+		BatchSize:       100,
+		MaxRetries:      cfg.TaskMaxRetries,
+		DispatchTimeout: time.Duration(cfg.DispatchTimeoutSeconds) * time.Second,
+	}
+	go sw.Run(db.WithActor(ctx, "sweeper"))
+
+	sched := &scheduler.Scheduler{
+		DB:                 pool,
+		Publisher:          publisher,
+		Interval:           time.Duration(cfg.SchedulerIntervalMillis) * time.Millisecond,
+		MaxQueuedPerStep:   cfg.SchedulerMaxQueuedPerStep,
+		DefaultMaxInFlight: cfg.TenantMaxInFlight,
+	}
+	go sched.Run(ctx)
+
 	conn, err := amqp.Dial(cfg.RabbitURL())
 	if err != nil {
 		panic(err)
@@ -56,33 +114,35 @@ func main() {
 	}
 	defer ch.Close()
 
-	_, err = ch.QueueDeclare(
-		cfg.RabbitQueue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
+	if err := mq.DeclareTopology(ch, cfg.RabbitExchange, cfg.RabbitQueue, pipeline.AllSteps); err != nil {
 		panic(err)
 	}
 
-	if err := ch.Qos(1, 0, false); err != nil {
+	// A channel-wide prefetch of 1, so a worker consuming several step queues
+	// still holds a single unacked message at a time.
+	if err := ch.Qos(1, 0, true); err != nil {
 		panic(err)
 	}
 
-	msgs, err := ch.Consume(
-		cfg.RabbitQueue,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		panic(err)
+	msgs := make(chan amqp.Delivery)
+	for _, step := range steps {
+		deliveries, err := ch.Consume(
+			mq.QueueName(cfg.RabbitQueue, step),
+			"",
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			for d := range deliveries {
+				msgs <- d
+			}
+		}()
 	}
 
 	workerID, _ := os.Hostname()
@@ -90,77 +150,46 @@ func main() {
 		workerID = "worker-unknown"
 	}
 
-	log.Printf("worker started: %s", workerID)
-
-	for msg := range msgs {
-		log.Printf("received message: %s", string(msg.Body))
-		var task mq.TaskMessage
-		if err := json.Unmarshal(msg.Body, &task); err != nil {
-			log.Printf("bad message: %v", err)
-			_ = msg.Reject(false)
-			continue
-		}
+	log.Printf("worker started: %s steps=%s", workerID, strings.Join(steps, ","))
 
-		log.Printf("decoded task: id=%s media=%s step=%s", task.TaskID, task.MediaID, task.Step)
-		claimed, err := db.ClaimTask(ctx, pool, task.TaskID, workerID, cfg.TaskLeaseSeconds)
-		if err != nil {
-			log.Printf("claim failed: %v", err)
-			_ = msg.Nack(false, true)
-			continue
-		}
-		if !claimed {
-			log.Printf("task %s not claimed (already locked or not eligible)", task.TaskID)
-			_ = msg.Ack(false)
-			continue
-		}
+	fetcher, err := fetch.New(fetch.Options{
+		Timeout:      time.Duration(cfg.FetchTimeoutSeconds) * time.Second,
+		MaxBytes:     cfg.FetchMaxBytes,
+		MaxRedirects: cfg.FetchMaxRedirects,
+		AllowCIDRs:   strings.Split(cfg.FetchAllowCIDRs, ","),
+	})
+	if err != nil {
+		panic(err)
+	}
 
-		row, err := db.GetTask(ctx, pool, task.TaskID)
-		if err != nil {
-			log.Printf("load task failed: %v", err)
-			_ = msg.Nack(false, true)
-			continue
-		}
+	w := &worker{
+		id:      workerID,
+		cfg:     cfg,
+		pool:    pool,
+		store:   store,
+		fetcher: fetcher,
+	}
+	workerCtx := db.WithActor(ctx, "worker:"+workerID)
+	for msg := range msgs {
+		w.handle(workerCtx, msg)
+	}
+}
 
-		log.Printf("loaded task row: id=%s status=%s retry=%d output=%s", row.ID, row.Status, row.RetryCount, row.OutputKey)
-		exists, err := store.ObjectExists(ctx, row.OutputKey)
-		if err != nil {
-			log.Printf("stat output failed: %v", err)
-			_ = handleFailure(ctx, pool, msg, row.ID, row.RetryCount, cfg.TaskMaxRetries, err)
+// parseSteps validates the --steps flag.
+func parseSteps(v string) ([]string, error) {
+	var steps []string
+	for _, step := range strings.Split(v, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
 			continue
 		}
-		if exists {
-			log.Printf("output exists, skipping task %s", row.ID)
-			_ = db.MarkTaskSucceeded(ctx, pool, row.ID)
-			obs.TasksSkipped.Inc()
-			_ = msg.Ack(false)
-			continue
-		}
-
-		log.Printf("processing task %s step=%s", row.ID, row.Step)
-		// Simulate work
-		time.Sleep(500 * time.Millisecond)
-
-		if err := db.MarkTaskSucceeded(ctx, pool, row.ID); err != nil {
-			log.Printf("mark succeeded failed: %v", err)
-			_ = handleFailure(ctx, pool, msg, row.ID, row.RetryCount, cfg.TaskMaxRetries, err)
-			continue
+		if !slices.Contains(pipeline.AllSteps, step) {
+			return nil, fmt.Errorf("unknown step %q (known: %s)", step, strings.Join(pipeline.AllSteps, ","))
 		}
-
-		obs.TasksProcessed.Inc()
-		_ = msg.Ack(false)
-		log.Printf("task %s done", row.ID)
+		steps = append(steps, step)
 	}
-}
-
-func handleFailure(ctx context.Context, pool *pgxpool.Pool, msg amqp.Delivery, taskID string, retryCount int, maxRetries int, err error) error {
-	if retryCount+1 >= maxRetries {
-		_ = db.MarkTaskFailed(ctx, pool, taskID, err.Error())
-		obs.TasksFailed.Inc()
-		_ = msg.Nack(false, false)
-		return nil
-	}
-	_ = db.MarkTaskRetry(ctx, pool, taskID, err.Error(), 30*time.Second)
-	obs.TasksRetried.Inc()
-	_ = msg.Nack(false, true)
-	return nil
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("--steps must name at least one step")
+	}
+	return steps, nil
 }
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/oklog/ulid/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"sys-design/internal/config"
+	"sys-design/internal/db"
+	"sys-design/internal/fetch"
+	"sys-design/internal/mq"
+	"sys-design/internal/obs"
+	"sys-design/internal/pipeline"
+	"sys-design/internal/storage"
+)
+
+type worker struct {
+	id      string
+	cfg     *config.Config
+	pool    *pgxpool.Pool
+	store   *storage.MinioStore
+	fetcher *fetch.Fetcher
+}
+
+func (w *worker) handle(ctx context.Context, msg amqp.Delivery) {
+	log.Printf("received message: %s", string(msg.Body))
+	// Rejected messages go to the dead-letter queue.
+	task, err := mq.DecodeTask(msg)
+	if err != nil {
+		log.Printf("bad message: %v", err)
+		reason := "malformed"
+		if errors.Is(err, mq.ErrUnsupportedVersion) {
+			reason = "unsupported_version"
+		}
+		obs.TasksDeadLettered.WithLabelValues(reason).Inc()
+		_ = msg.Reject(false)
+		return
+	}
+
+	log.Printf("decoded task: id=%s media=%s step=%s attempt=%d message=%s trace=%s", task.TaskID, task.MediaID, task.Step, task.Attempt, task.MessageID, task.TraceID)
+	epoch, claimed, err := db.ClaimTask(ctx, w.pool, task.TaskID, w.id, w.cfg.TaskLeaseSeconds)
+	if err != nil {
+		log.Printf("claim failed: %v", err)
+		_ = msg.Nack(false, true)
+		return
+	}
+	if !claimed {
+		log.Printf("task %s not claimed (already locked, cancelled or not eligible)", task.TaskID)
+		_ = msg.Ack(false)
+		return
+	}
+
+	row, err := db.GetTask(ctx, w.pool, task.TaskID)
+	if err != nil {
+		log.Printf("load task failed: %v", err)
+		_ = msg.Nack(false, true)
+		return
+	}
+	if row.LeaseEpoch != epoch {
+		log.Printf("task %s re-claimed under epoch %d before it was loaded", row.ID, row.LeaseEpoch)
+		obs.TasksFenced.Inc()
+		_ = msg.Ack(false)
+		return
+	}
+
+	attemptID, err := db.StartTaskAttempt(ctx, w.pool, row.ID, w.id, epoch)
+	if err != nil {
+		log.Printf("record attempt for task %s failed: %v", row.ID, err)
+	}
+
+	// The step runs under its own context, which the heartbeat cancels once
+	// the lease can no longer be extended (e.g. the task was cancelled).
+	stepCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.heartbeat(stepCtx, cancel, row.ID, epoch)
+
+	log.Printf("loaded task row: id=%s status=%s retry=%d epoch=%d output=%s", row.ID, row.Status, row.RetryCount, epoch, row.OutputKey)
+	exists, err := w.store.ObjectExists(stepCtx, row.OutputKey)
+	if err != nil {
+		log.Printf("stat output failed: %v", err)
+		w.handleFailure(ctx, stepCtx, msg, row, attemptID, err)
+		return
+	}
+	if exists {
+		log.Printf("output exists, skipping task %s", row.ID)
+		obs.TasksSkipped.Inc()
+	} else {
+		log.Printf("processing task %s step=%s", row.ID, row.Step)
+		if err := w.runStep(stepCtx, row); err != nil {
+			log.Printf("step %s failed: %v", row.Step, err)
+			w.handleFailure(ctx, stepCtx, msg, row, attemptID, err)
+			return
+		}
+		if stepCtx.Err() != nil {
+			w.abandon(ctx, msg, row, attemptID)
+			return
+		}
+		promoted, err := w.promote(ctx, row)
+		if err != nil {
+			log.Printf("promote output failed: %v", err)
+			w.handleFailure(ctx, stepCtx, msg, row, attemptID, err)
+			return
+		}
+		if !promoted {
+			w.abandon(ctx, msg, row, attemptID)
+			return
+		}
+	}
+
+	// Chain the next step before marking this one done, so a crash in
+	// between is retried rather than leaving the pipeline stalled.
+	if err := advancePipeline(ctx, w.pool, row); err != nil {
+		log.Printf("advance pipeline failed: %v", err)
+		w.handleFailure(ctx, stepCtx, msg, row, attemptID, err)
+		return
+	}
+
+	if err := db.MarkTaskSucceeded(ctx, w.pool, row.ID, w.id, row.LeaseEpoch); err != nil {
+		log.Printf("mark succeeded failed: %v", err)
+		// The task was re-claimed after the output was promoted: the output
+		// is complete, so leave it for the new owner to skip over.
+		if errors.Is(err, db.ErrLeaseLost) {
+			obs.TasksFenced.Inc()
+			w.finishAttempt(ctx, attemptID, "ABANDONED", err.Error())
+			_ = msg.Ack(false)
+			return
+		}
+		if errors.Is(err, db.ErrIllegalTransition) {
+			w.abandon(ctx, msg, row, attemptID)
+			return
+		}
+		w.handleFailure(ctx, stepCtx, msg, row, attemptID, err)
+		return
+	}
+
+	w.finishAttempt(ctx, attemptID, "SUCCEEDED", "")
+	obs.TasksProcessed.Inc()
+	_ = msg.Ack(false)
+	log.Printf("task %s done", row.ID)
+}
+
+// heartbeat extends the task lease every third of the lease period until ctx
+// is done. If the lease cannot be extended because the task is no longer
+// RUNNING under this worker and epoch, it calls cancel to abort the step.
+func (w *worker) heartbeat(ctx context.Context, cancel context.CancelFunc, taskID string, epoch int64) {
+	ticker := time.NewTicker(time.Duration(w.cfg.TaskLeaseSeconds) * time.Second / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := db.ExtendLease(ctx, w.pool, taskID, w.id, epoch, w.cfg.TaskLeaseSeconds)
+			if err != nil {
+				log.Printf("extend lease for task %s failed: %v", taskID, err)
+				continue
+			}
+			if !ok {
+				log.Printf("task %s lost its lease, aborting", taskID)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// promote renews the lease under the task's epoch and, while it is still
+// held, copies the attempt's output to the task's output key. It reports
+// false without writing anything once the lease has been revoked or the task
+// re-claimed. The renewal leaves a full lease period for the copy, so no
+// other attempt can be promoting at the same time.
+func (w *worker) promote(ctx context.Context, row *db.ProcessingTaskRow) (bool, error) {
+	held, err := db.ExtendLease(ctx, w.pool, row.ID, w.id, row.LeaseEpoch, w.cfg.TaskLeaseSeconds)
+	if err != nil || !held {
+		if err == nil {
+			obs.TasksFenced.Inc()
+		}
+		return false, err
+	}
+	tmpKey := pipeline.AttemptKey(row.OutputKey, row.LeaseEpoch)
+	if err := w.store.CopyObject(ctx, tmpKey, row.OutputKey); err != nil {
+		return false, err
+	}
+	w.discard(ctx, row)
+	return true, nil
+}
+
+// discard removes the output the current attempt wrote, if any. The
+// promoted output is never touched: it may already be another attempt's.
+func (w *worker) discard(ctx context.Context, row *db.ProcessingTaskRow) {
+	tmpKey := pipeline.AttemptKey(row.OutputKey, row.LeaseEpoch)
+	if err := w.store.DeleteObject(ctx, tmpKey); err != nil {
+		log.Printf("cleanup output %s failed: %v", tmpKey, err)
+	}
+}
+
+// abandon gives up on a task whose lease was revoked, removing any partial
+// output the step may have written.
+func (w *worker) abandon(ctx context.Context, msg amqp.Delivery, row *db.ProcessingTaskRow, attemptID int64) {
+	w.discard(ctx, row)
+	w.finishAttempt(ctx, attemptID, "ABANDONED", "lease revoked")
+	obs.TasksCancelled.Inc()
+	_ = msg.Ack(false)
+	log.Printf("task %s abandoned", row.ID)
+}
+
+func (w *worker) handleFailure(ctx, stepCtx context.Context, msg amqp.Delivery, row *db.ProcessingTaskRow, attemptID int64, err error) {
+	if stepCtx.Err() != nil {
+		w.abandon(ctx, msg, row, attemptID)
+		return
+	}
+	w.discard(ctx, row)
+	if row.RetryCount+1 >= w.cfg.TaskMaxRetries || errors.Is(err, fetch.ErrPermanent) {
+		if !w.fenced(ctx, msg, row, attemptID, db.MarkTaskFailed(ctx, w.pool, row.ID, w.id, row.LeaseEpoch, err.Error())) {
+			return
+		}
+		w.finishAttempt(ctx, attemptID, "FAILED", err.Error())
+		if err := db.MarkMediaFailed(ctx, w.pool, row.MediaID, row.Generation); err != nil {
+			log.Printf("mark media %s failed: %v", row.MediaID, err)
+		}
+		obs.TasksFailed.Inc()
+		obs.TasksDeadLettered.WithLabelValues("failed").Inc()
+		_ = msg.Nack(false, false)
+		return
+	}
+	// The scheduler dispatches the task again once the backoff has elapsed; a
+	// requeued message would only arrive early and be dropped by ClaimTask.
+	if !w.fenced(ctx, msg, row, attemptID, db.MarkTaskRetry(ctx, w.pool, row.ID, w.id, row.LeaseEpoch, err.Error(), 30*time.Second)) {
+		return
+	}
+	w.finishAttempt(ctx, attemptID, "RETRY", err.Error())
+	obs.TasksRetried.Inc()
+	_ = msg.Ack(false)
+}
+
+// fenced checks the result of a fenced task write made on failure and
+// reports whether the worker still owned the task. A worker whose lease was
+// revoked or taken over closes its attempt as ABANDONED and must not touch
+// the media, which belongs to the task's new owner. On other errors the
+// attempt is left open for the sweeper to expire.
+func (w *worker) fenced(ctx context.Context, msg amqp.Delivery, row *db.ProcessingTaskRow, attemptID int64, err error) bool {
+	if err == nil {
+		return true
+	}
+	log.Printf("record failure of task %s: %v", row.ID, err)
+	if errors.Is(err, db.ErrLeaseLost) || errors.Is(err, db.ErrIllegalTransition) {
+		obs.TasksFenced.Inc()
+		w.finishAttempt(ctx, attemptID, "ABANDONED", err.Error())
+	}
+	_ = msg.Ack(false)
+	return false
+}
+
+// finishAttempt closes the attempt row; attempts that could not be recorded
+// have ID 0 and are skipped.
+func (w *worker) finishAttempt(ctx context.Context, attemptID int64, outcome string, errMsg string) {
+	if attemptID == 0 {
+		return
+	}
+	if err := db.FinishTaskAttempt(ctx, w.pool, attemptID, outcome, errMsg); err != nil {
+		log.Printf("record attempt %d outcome failed: %v", attemptID, err)
+	}
+}
+
+// runStep executes a pipeline step, writing to the attempt's key for promote
+// to publish. The fetch step downloads the source URL held in the input key.
+// Image transforms are simulated: the step takes some time and writes its
+// input through.
+func (w *worker) runStep(ctx context.Context, row *db.ProcessingTaskRow) error {
+	tmpKey := pipeline.AttemptKey(row.OutputKey, row.LeaseEpoch)
+	if row.Step == "fetch" {
+		data, contentType, err := w.fetcher.Fetch(ctx, row.InputKey)
+		if err != nil {
+			return err
+		}
+		if err := w.store.PutObject(ctx, tmpKey, data, contentType); err != nil {
+			return err
+		}
+		return db.SetMediaSize(ctx, w.pool, row.MediaID, int64(len(data)))
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(500 * time.Millisecond):
+	}
+	return w.store.CopyObject(ctx, row.InputKey, tmpKey)
+}
+
+// advancePipeline creates the task for the step after row within the same
+// generation, or marks the media READY when row was the last step. The new
+// task waits for the scheduler to dispatch it.
+func advancePipeline(ctx context.Context, pool *pgxpool.Pool, row *db.ProcessingTaskRow) error {
+	m, err := db.GetMedia(ctx, pool, row.MediaID)
+	if err != nil {
+		return err
+	}
+	if m.Generation != row.Generation {
+		log.Printf("task %s belongs to stale generation %d (current %d)", row.ID, row.Generation, m.Generation)
+		return nil
+	}
+	if m.Status == "CANCELLED" {
+		log.Printf("media %s was cancelled, not advancing", m.ID)
+		return nil
+	}
+
+	step, ok := pipeline.Next(m.Steps, row.Step)
+	if !ok {
+		err := db.MarkMediaReady(ctx, pool, m.ID, m.Generation, row.OutputKey)
+		if errors.Is(err, db.ErrStaleGeneration) || errors.Is(err, db.ErrIllegalTransition) {
+			log.Printf("media %s not marked ready: %v", m.ID, err)
+			return nil
+		}
+		return err
+	}
+
+	next := db.ProcessingTaskInput{
+		ID:         ulid.Make().String(),
+		MediaID:    m.ID,
+		Generation: m.Generation,
+		Step:       step,
+		Status:     "PENDING",
+		InputKey:   row.OutputKey,
+		OutputKey:  pipeline.OutputKey(m.TenantID(), m.ID, m.Generation, step),
+		Priority:   row.Priority,
+
+		CorrelationID: row.CorrelationID,
+		TraceID:       row.TraceID,
+	}
+	inserted, err := db.InsertProcessingTask(ctx, pool, next)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		obs.TasksCreated.Inc()
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS processing_task;
+DROP TABLE IF EXISTS media;
+DROP TYPE IF EXISTS task_step;
+DROP TYPE IF EXISTS task_status;
+DROP TYPE IF EXISTS media_status;
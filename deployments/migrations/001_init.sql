@@ -1,8 +1,24 @@
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
-CREATE TYPE media_status AS ENUM ('INIT', 'UPLOADED', 'PROCESSING', 'READY', 'FAILED');
-CREATE TYPE task_status AS ENUM ('PENDING', 'RUNNING', 'SUCCEEDED', 'FAILED', 'RETRY');
-CREATE TYPE task_step AS ENUM ('resize', 'compress', 'webp');
+-- Postgres has no CREATE TYPE IF NOT EXISTS; skip types that already exist
+-- so the migration can be recorded against a database set up by hand.
+DO $$
+BEGIN
+  CREATE TYPE media_status AS ENUM ('INIT', 'UPLOADED', 'PROCESSING', 'READY', 'FAILED');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$
+BEGIN
+  CREATE TYPE task_status AS ENUM ('PENDING', 'RUNNING', 'SUCCEEDED', 'FAILED', 'RETRY');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$
+BEGIN
+  CREATE TYPE task_step AS ENUM ('resize', 'compress', 'webp');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 
 CREATE TABLE IF NOT EXISTS media (
   id TEXT PRIMARY KEY,
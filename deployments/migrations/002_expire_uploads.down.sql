@@ -0,0 +1,3 @@
+-- Enum values cannot be dropped; EXPIRED stays in media_status.
+DROP INDEX IF EXISTS idx_media_status_created;
+ALTER TABLE media DROP COLUMN IF EXISTS objects_purged_at;
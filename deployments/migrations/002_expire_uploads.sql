@@ -0,0 +1,5 @@
+ALTER TYPE media_status ADD VALUE IF NOT EXISTS 'EXPIRED';
+
+ALTER TABLE media ADD COLUMN IF NOT EXISTS objects_purged_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_media_status_created ON media(status, created_at);
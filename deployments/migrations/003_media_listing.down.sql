@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_media_created_at;
+DROP INDEX IF EXISTS idx_media_profile_id;
+DROP INDEX IF EXISTS idx_media_owner_id;
+DROP INDEX IF EXISTS idx_media_status_id;
+ALTER TABLE media DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE media DROP COLUMN IF EXISTS profile;
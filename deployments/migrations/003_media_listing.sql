@@ -0,0 +1,8 @@
+ALTER TABLE media ADD COLUMN IF NOT EXISTS profile TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE media ADD COLUMN IF NOT EXISTS owner_id TEXT;
+
+-- Listing pages by id DESC; ULIDs sort by creation time.
+CREATE INDEX IF NOT EXISTS idx_media_status_id ON media(status, id DESC);
+CREATE INDEX IF NOT EXISTS idx_media_owner_id ON media(owner_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_media_profile_id ON media(profile, id DESC);
+CREATE INDEX IF NOT EXISTS idx_media_created_at ON media(created_at);
@@ -0,0 +1,4 @@
+-- Enum values cannot be dropped; CANCELLED stays in task_status.
+DROP INDEX IF EXISTS idx_media_purge_after;
+ALTER TABLE media DROP COLUMN IF EXISTS purge_after;
+ALTER TABLE media DROP COLUMN IF EXISTS deleted_at;
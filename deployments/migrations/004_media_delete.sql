@@ -0,0 +1,6 @@
+ALTER TYPE task_status ADD VALUE IF NOT EXISTS 'CANCELLED';
+
+ALTER TABLE media ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS purge_after TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_media_purge_after ON media(purge_after) WHERE deleted_at IS NOT NULL;
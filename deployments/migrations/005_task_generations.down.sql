@@ -0,0 +1,6 @@
+-- Fails if a media has tasks from more than one generation.
+DROP INDEX IF EXISTS uq_processing_task_media_generation_step;
+ALTER TABLE processing_task ADD CONSTRAINT processing_task_media_id_step_key UNIQUE (media_id, step);
+ALTER TABLE processing_task DROP COLUMN IF EXISTS generation;
+ALTER TABLE media DROP COLUMN IF EXISTS steps;
+ALTER TABLE media DROP COLUMN IF EXISTS generation;
@@ -0,0 +1,9 @@
+-- A generation is one run of a media's pipeline. Reprocessing starts a new
+-- generation, so the same step can run again without conflicting with the
+-- previous run's task row.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS generation INT NOT NULL DEFAULT 1;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS steps TEXT[] NOT NULL DEFAULT ARRAY['resize'];
+
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS generation INT NOT NULL DEFAULT 1;
+ALTER TABLE processing_task DROP CONSTRAINT IF EXISTS processing_task_media_id_step_key;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_processing_task_media_generation_step ON processing_task(media_id, generation, step);
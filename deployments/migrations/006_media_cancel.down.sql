@@ -0,0 +1 @@
+-- Enum values cannot be dropped; CANCELLED stays in media_status.
@@ -0,0 +1 @@
+ALTER TYPE media_status ADD VALUE IF NOT EXISTS 'CANCELLED';
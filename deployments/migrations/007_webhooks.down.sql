@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TYPE IF EXISTS webhook_status;
+ALTER TABLE media DROP COLUMN IF EXISTS callback_url;
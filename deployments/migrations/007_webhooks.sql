@@ -0,0 +1,27 @@
+ALTER TABLE media ADD COLUMN IF NOT EXISTS callback_url TEXT;
+
+DO $$
+BEGIN
+  CREATE TYPE webhook_status AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+-- One row per event to deliver; doubles as the delivery log.
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id TEXT PRIMARY KEY,
+  media_id TEXT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  event TEXT NOT NULL,
+  url TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status webhook_status NOT NULL DEFAULT 'PENDING',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_status_code INT,
+  last_error TEXT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_status_next ON webhook_delivery(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_media ON webhook_delivery(media_id);
@@ -0,0 +1,16 @@
+-- Stored responses for write requests carrying an Idempotency-Key header.
+-- status_code is NULL while the original request is still in flight.
+CREATE TABLE IF NOT EXISTS idempotency_key (
+  scope TEXT NOT NULL,
+  key TEXT NOT NULL,
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  status_code INT,
+  response_body BYTEA,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMPTZ,
+  PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_created ON idempotency_key(created_at);
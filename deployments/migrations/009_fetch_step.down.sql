@@ -0,0 +1 @@
+-- Enum values cannot be dropped; fetch stays in task_step.
@@ -0,0 +1 @@
+ALTER TYPE task_step ADD VALUE IF NOT EXISTS 'fetch' BEFORE 'resize';
@@ -0,0 +1,12 @@
+-- API keys. Only the SHA-256 of a key is stored; the plaintext is shown once
+-- when the key is created. owner_id is copied onto media created with the key.
+CREATE TABLE IF NOT EXISTS api_key (
+  id TEXT PRIMARY KEY,
+  owner_id TEXT NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  key_hash TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_owner_id ON api_key(owner_id);
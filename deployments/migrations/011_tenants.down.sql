@@ -0,0 +1,3 @@
+ALTER TABLE media DROP COLUMN IF EXISTS size_bytes;
+DROP TABLE IF EXISTS tenant_usage;
+DROP TABLE IF EXISTS tenant;
@@ -0,0 +1,22 @@
+-- Tenants own media through the owner_id of their API keys. NULL quotas fall
+-- back to the configured defaults.
+CREATE TABLE IF NOT EXISTS tenant (
+  id TEXT PRIMARY KEY,
+  storage_quota_bytes BIGINT,
+  monthly_processing_quota INT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO tenant (id) SELECT DISTINCT owner_id FROM api_key ON CONFLICT DO NOTHING;
+
+-- Pipeline runs started per tenant and calendar month (UTC).
+CREATE TABLE IF NOT EXISTS tenant_usage (
+  tenant_id TEXT NOT NULL,
+  month DATE NOT NULL,
+  processing_runs INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (tenant_id, month)
+);
+
+-- Size of the original object, counted against the tenant's storage quota
+-- until the media's objects are purged.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS size_bytes BIGINT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_processing_task_deferred;
+ALTER TABLE processing_task DROP COLUMN IF EXISTS deferred;
@@ -0,0 +1,5 @@
+-- Tasks admitted while the queue is saturated are stored but not published;
+-- the API publishes them once admission reopens.
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS deferred BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_processing_task_deferred ON processing_task(created_at) WHERE deferred;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_processing_task_sweep;
+ALTER TABLE processing_task DROP COLUMN IF EXISTS enqueued_at;
+ALTER TABLE processing_task DROP COLUMN IF EXISTS priority;
+ALTER TABLE media DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,9 @@
+-- Priority of the current pipeline run (0-9, higher first). Tasks copy it so
+-- the queue and the sweeper can order by it.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+
+-- When the sweeper last republished the task.
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS enqueued_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_processing_task_sweep ON processing_task(priority DESC, lock_until) WHERE status IN ('RETRY', 'RUNNING');
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_processing_task_active;
+ALTER TABLE processing_task ALTER COLUMN deferred SET DEFAULT FALSE;
+ALTER TABLE tenant DROP COLUMN IF EXISTS max_in_flight;
+ALTER TABLE tenant DROP COLUMN IF EXISTS weight;
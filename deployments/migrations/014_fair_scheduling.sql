@@ -0,0 +1,10 @@
+-- Share of worker capacity per tenant: the scheduler keeps in-flight tasks
+-- proportional to weight, capped at max_in_flight (NULL = configured default).
+ALTER TABLE tenant ADD COLUMN IF NOT EXISTS weight INT NOT NULL DEFAULT 1;
+ALTER TABLE tenant ADD COLUMN IF NOT EXISTS max_in_flight INT;
+
+-- Every new or retried task now waits in Postgres until the scheduler
+-- publishes it; deferred is cleared on dispatch.
+ALTER TABLE processing_task ALTER COLUMN deferred SET DEFAULT TRUE;
+
+CREATE INDEX IF NOT EXISTS idx_processing_task_active ON processing_task(step) WHERE status IN ('PENDING', 'RETRY', 'RUNNING');
@@ -0,0 +1,2 @@
+ALTER TABLE processing_task DROP COLUMN IF EXISTS trace_id;
+ALTER TABLE processing_task DROP COLUMN IF EXISTS correlation_id;
@@ -0,0 +1,4 @@
+-- Correlation and trace IDs of the request that started a pipeline run,
+-- inherited by every task of the run and sent with each task message.
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS correlation_id TEXT;
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS trace_id TEXT;
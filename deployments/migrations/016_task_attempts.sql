@@ -0,0 +1,15 @@
+-- One row per claim of a task: who ran it, when, and how it ended.
+-- outcome is NULL while the attempt runs, then SUCCEEDED, RETRY, FAILED,
+-- ABANDONED (lease revoked, e.g. cancelled) or LEASE_EXPIRED (worker died).
+CREATE TABLE IF NOT EXISTS task_attempt (
+  id BIGSERIAL PRIMARY KEY,
+  task_id TEXT NOT NULL REFERENCES processing_task(id) ON DELETE CASCADE,
+  attempt INT NOT NULL,
+  worker_id TEXT NOT NULL,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ,
+  outcome TEXT,
+  error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_attempt_task ON task_attempt(task_id, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS media_event;
+DROP FUNCTION IF EXISTS media_event_append_only();
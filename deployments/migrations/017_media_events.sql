@@ -0,0 +1,23 @@
+-- Append-only audit trail of media and task state changes, written in the
+-- same transaction as the change. media_id has no foreign key so the
+-- history outlives a hard delete.
+CREATE TABLE IF NOT EXISTS media_event (
+  id BIGSERIAL PRIMARY KEY,
+  media_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_event_media ON media_event(media_id, id);
+
+CREATE OR REPLACE FUNCTION media_event_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'media_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS media_event_append_only ON media_event;
+CREATE TRIGGER media_event_append_only BEFORE UPDATE OR DELETE ON media_event
+  FOR EACH ROW EXECUTE FUNCTION media_event_append_only();
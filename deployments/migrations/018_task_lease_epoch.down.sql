@@ -0,0 +1,2 @@
+ALTER TABLE task_attempt DROP COLUMN IF EXISTS lease_epoch;
+ALTER TABLE processing_task DROP COLUMN IF EXISTS lease_epoch;
@@ -0,0 +1,5 @@
+-- Fencing token for task leases. ClaimTask increments lease_epoch, and every
+-- later write by the worker must present the epoch it claimed, so a worker
+-- whose lease expired cannot overwrite the task's next owner.
+ALTER TABLE processing_task ADD COLUMN IF NOT EXISTS lease_epoch BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_attempt ADD COLUMN IF NOT EXISTS lease_epoch BIGINT;
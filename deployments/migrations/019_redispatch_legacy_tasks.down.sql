@@ -0,0 +1 @@
+-- Nothing to revert: re-deferred tasks have been dispatched again.
@@ -0,0 +1,7 @@
+-- Tasks published before per-step queues (and before the scheduler held
+-- every task) may have their only message in the old single queue, which no
+-- worker consumes. Hand every waiting task back to the scheduler so it
+-- publishes it to its step queue. A task whose message already sits in a
+-- step queue just gets a second one, which ClaimTask discards.
+UPDATE processing_task SET deferred = TRUE
+WHERE status IN ('PENDING', 'RETRY') AND NOT deferred;
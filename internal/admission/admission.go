@@ -0,0 +1,68 @@
+package admission
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+)
+
+// Controller decides whether the API may start new pipeline runs. It
+// periodically counts the tasks waiting for a worker in Postgres and sheds
+// load while the backlog is at or above MaxBacklog; 0 disables the check.
+// The RabbitMQ queues are no measure of load: the scheduler keeps them at a
+// few messages per step and holds everything else back in Postgres.
+type Controller struct {
+	DB         *pgxpool.Pool
+	MaxBacklog int
+	Interval   time.Duration
+
+	shedding atomic.Bool
+}
+
+// Shedding reports whether new work should currently be refused.
+func (c *Controller) Shedding() bool {
+	return c.shedding.Load()
+}
+
+func (c *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RunOnce(ctx); err != nil {
+			log.Printf("admission check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce refreshes the admission state. On errors the previous state is
+// kept.
+func (c *Controller) RunOnce(ctx context.Context) error {
+	backlog, err := db.CountTaskBacklog(ctx, c.DB)
+	if err != nil {
+		return err
+	}
+	obs.TaskBacklog.Set(float64(backlog))
+
+	shedding := c.MaxBacklog > 0 && backlog >= c.MaxBacklog
+	if c.shedding.Swap(shedding) != shedding {
+		log.Printf("admission shedding=%t (backlog %d)", shedding, backlog)
+	}
+	if shedding {
+		obs.AdmissionOpen.Set(0)
+	} else {
+		obs.AdmissionOpen.Set(1)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/obs"
+)
+
+// admit reports whether a new pipeline run may start. While the admission
+// controller sheds load it answers 503 with Retry-After.
+func (s *Server) admit(c *gin.Context) bool {
+	if s.Admission == nil || !s.Admission.Shedding() {
+		return true
+	}
+
+	obs.AdmissionRejections.Inc()
+	c.Header("Retry-After", strconv.Itoa(max(1, int(s.Admission.Interval.Seconds()))))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "processing is saturated, retry later"})
+	return false
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+)
+
+const maxBatchSize = 100
+
+type BatchUploadURLRequest struct {
+	Items []UploadURLRequest `json:"items"`
+}
+
+// BatchUploadURLItem is either a created upload or the error for that item.
+type BatchUploadURLItem struct {
+	Index int `json:"index"`
+	*UploadURLResponse
+	Error string `json:"error,omitempty"`
+}
+
+type BatchUploadURLResponse struct {
+	Items []BatchUploadURLItem `json:"items"`
+}
+
+type BatchGetMediaRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type BatchGetMediaItem struct {
+	*MediaResponse
+	MediaID string `json:"media_id"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BatchGetMediaResponse struct {
+	Items []BatchGetMediaItem `json:"items"`
+}
+
+// handleBatchUploadURLs creates up to maxBatchSize media and presigned upload
+// URLs. Items that fail validation are reported individually; all valid items
+// are inserted in one transaction.
+func (s *Server) handleBatchUploadURLs(c *gin.Context) {
+	var req BatchUploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items must contain 1 to 100 entries"})
+		return
+	}
+	if !s.checkStorageQuota(c) {
+		return
+	}
+
+	resp := BatchUploadURLResponse{Items: make([]BatchUploadURLItem, len(req.Items))}
+	var media []db.MediaInput
+	for i, item := range req.Items {
+		resp.Items[i].Index = i
+		m, upload, rerr := s.prepareUpload(context.Background(), ownerID(c), item)
+		if rerr != nil {
+			resp.Items[i].Error = rerr.Message
+			continue
+		}
+		resp.Items[i].UploadURLResponse = upload
+		media = append(media, *m)
+	}
+
+	if len(media) > 0 {
+		// Every valid item is a run, so the batch fits the quota or is
+		// refused as a whole.
+		if !s.chargeRuns(c, len(media)) {
+			return
+		}
+		if err := db.InsertMediaBatch(actorCtx(c), s.DB, media); err != nil {
+			s.refundRuns(c, len(media))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create media"})
+			return
+		}
+		obs.TasksCreated.Add(float64(len(media)))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleMediaAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":batchGet":
+		s.handleBatchGetMedia(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	}
+}
+
+// handleBatchGetMedia returns the status of up to maxBatchSize media in
+// request order, with a per-item error for IDs that do not exist.
+func (s *Server) handleBatchGetMedia(c *gin.Context) {
+	var req BatchGetMediaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must contain 1 to 100 entries"})
+		return
+	}
+
+	found, err := db.GetMediaBatch(context.Background(), s.DB, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get media"})
+		return
+	}
+
+	resp := BatchGetMediaResponse{Items: make([]BatchGetMediaItem, len(req.IDs))}
+	for i, id := range req.IDs {
+		resp.Items[i].MediaID = id
+		m, ok := found[id]
+		if !ok || !ownsMedia(c, m) {
+			resp.Items[i].Error = "not found"
+			continue
+		}
+		mr := toMediaResponse(m)
+		resp.Items[i].MediaResponse = &mr
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"sys-design/internal/db"
+)
+
+// handleCancelMedia stops processing of media that has not finished yet.
+func (s *Server) handleCancelMedia(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := s.getOwnedMedia(c, id); !ok {
+		return
+	}
+
+	if err := db.CancelMedia(actorCtx(c), s.DB, id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if writeStateError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel media"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"media_id": id, "status": "CANCELLED"})
+}
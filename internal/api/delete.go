@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+type DeleteMediaResponse struct {
+	MediaID    string    `json:"media_id"`
+	Status     string    `json:"status"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// handleDeleteMedia soft-deletes media and cancels its outstanding tasks.
+// Objects are removed asynchronously by the janitor, which also hard-deletes
+// the row once the grace period has passed. ?purge=true skips the grace
+// period, e.g. for GDPR erasure requests.
+func (s *Server) handleDeleteMedia(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := s.getOwnedMedia(c, id); !ok {
+		return
+	}
+
+	grace := s.DeleteGrace
+	if c.Query("purge") == "true" {
+		grace = 0
+	}
+
+	deleted, err := db.SoftDeleteMedia(actorCtx(c), s.DB, id, grace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete media"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, DeleteMediaResponse{
+		MediaID:    id,
+		Status:     "DELETED",
+		PurgeAfter: time.Now().Add(grace).UTC(),
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+type MediaSnapshot struct {
+	Media MediaResponse  `json:"media"`
+	Tasks []TaskResponse `json:"tasks"`
+}
+
+// handleMediaEvents streams media status transitions and per-step task
+// progress as Server-Sent Events. The stream opens with a "snapshot" event of
+// the current state, then sends "media" and "task" events, and ends once the
+// media reaches a terminal status. If events were lost, because the client
+// fell behind or the listener reconnected, another "snapshot" follows.
+func (s *Server) handleMediaEvents(c *gin.Context) {
+	id := c.Param("id")
+
+	// Subscribe before reading the snapshot so no transition is lost between
+	// the two.
+	events, resync, unsubscribe := s.Events.Subscribe(id)
+	defer unsubscribe()
+
+	m, ok := s.getOwnedMedia(c, id)
+	if !ok {
+		return
+	}
+	snapshot, err := s.mediaSnapshot(m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tasks"})
+		return
+	}
+
+	// The server's WriteTimeout would otherwise cut the stream short.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("snapshot", snapshot)
+	c.Writer.Flush()
+	if isTerminalMediaStatus(m.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case ev := <-events:
+			c.SSEvent(ev.Type, ev)
+			c.Writer.Flush()
+			if ev.Type == db.EventTypeMedia && isTerminalMediaStatus(ev.Status) {
+				return
+			}
+		case <-resync:
+			// Buffered events predate the new snapshot.
+			for len(events) > 0 {
+				<-events
+			}
+			m, err := db.GetMedia(context.Background(), s.DB, id)
+			if err != nil {
+				// Deleted while the events were lost.
+				return
+			}
+			snapshot, err := s.mediaSnapshot(m)
+			if err != nil {
+				return
+			}
+			c.SSEvent("snapshot", snapshot)
+			c.Writer.Flush()
+			if isTerminalMediaStatus(m.Status) {
+				return
+			}
+		case <-heartbeat.C:
+			_, _ = c.Writer.WriteString(": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+func (s *Server) mediaSnapshot(m *db.MediaRow) (MediaSnapshot, error) {
+	tasks, err := db.ListTasksByMedia(context.Background(), s.DB, m.ID)
+	if err != nil {
+		return MediaSnapshot{}, err
+	}
+	snapshot := MediaSnapshot{Media: toMediaResponse(m), Tasks: make([]TaskResponse, 0, len(tasks))}
+	for _, t := range tasks {
+		snapshot.Tasks = append(snapshot.Tasks, toTaskResponse(t))
+	}
+	return snapshot, nil
+}
+
+func isTerminalMediaStatus(status string) bool {
+	switch status {
+	case "READY", "FAILED", "CANCELLED", "EXPIRED", "DELETED":
+		return true
+	}
+	return false
+}
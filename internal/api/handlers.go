@@ -2,8 +2,11 @@ package api
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,21 +14,42 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/oklog/ulid/v2"
 
+	"sys-design/internal/admission"
 	"sys-design/internal/db"
+	"sys-design/internal/events"
+	"sys-design/internal/fetch"
 	"sys-design/internal/mq"
 	"sys-design/internal/obs"
+	"sys-design/internal/pipeline"
 	"sys-design/internal/storage"
 )
 
 type Server struct {
-	DB        *pgxpool.Pool
-	Store     *storage.MinioStore
-	Publisher *mq.Publisher
+	DB          *pgxpool.Pool
+	Store       *storage.MinioStore
+	Events      *events.Hub
+	DeleteGrace time.Duration
+	// MaxWait caps ?wait= on GET /media/:id; keep it below the server's
+	// WriteTimeout so long polls are answered before the connection is cut.
+	MaxWait time.Duration
+	// Quotas for tenants without their own; 0 means unlimited.
+	DefaultStorageQuota int64
+	DefaultMonthlyQuota int
+	// RateLimiter, if set, limits every authenticated route.
+	RateLimiter *RateLimiter
+	// Admission, if set, gates new pipeline runs on the task backlog.
+	Admission *admission.Controller
+	// CallbackAllowCIDRs re-enables private ranges for callback_url, matching
+	// the worker's WEBHOOK_ALLOW_CIDRS.
+	CallbackAllowCIDRs []*net.IPNet
 }
 
 type UploadURLRequest struct {
 	ContentType string `json:"content_type"`
 	FileName    string `json:"file_name"`
+	Profile     string `json:"profile"`
+	CallbackURL string `json:"callback_url"`
+	Priority    *int   `json:"priority"`
 }
 
 type UploadURLResponse struct {
@@ -41,9 +65,35 @@ type CompleteUploadRequest struct {
 }
 
 type MediaResponse struct {
-	MediaID  string `json:"media_id"`
-	Status   string `json:"status"`
-	FinalURL string `json:"final_url,omitempty"`
+	MediaID    string    `json:"media_id"`
+	Status     string    `json:"status"`
+	FinalURL   string    `json:"final_url,omitempty"`
+	Profile    string    `json:"profile"`
+	Generation int       `json:"generation"`
+	Priority   int       `json:"priority"`
+	OwnerID    string    `json:"owner_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type ListMediaResponse struct {
+	Items      []MediaResponse `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var mediaStatuses = map[string]bool{
+	"INIT":       true,
+	"UPLOADED":   true,
+	"PROCESSING": true,
+	"READY":      true,
+	"FAILED":     true,
+	"EXPIRED":    true,
+	"CANCELLED":  true,
 }
 
 func (s *Server) RegisterRoutes(r *gin.Engine) {
@@ -52,9 +102,33 @@ func (s *Server) RegisterRoutes(r *gin.Engine) {
 	})
 
 	r.GET("/metrics", gin.WrapH(obs.MetricsHandler()))
-	r.POST("/upload-url", s.handleUploadURL)
-	r.POST("/complete-upload", s.handleCompleteUpload)
-	r.GET("/media/:id", s.handleGetMedia)
+
+	// Everything else is rate limited and needs an API key, and every write
+	// endpoint honours Idempotency-Key. Clients are limited by IP before the
+	// key lookup and by key after it.
+	guards := []gin.HandlerFunc{AuthMiddleware(s.DB)}
+	if s.RateLimiter != nil {
+		guards = []gin.HandlerFunc{s.RateLimiter.IPMiddleware(), AuthMiddleware(s.DB), s.RateLimiter.Middleware()}
+	}
+	authed := r.Group("", guards...)
+	idem := IdempotencyMiddleware(s.DB)
+	authed.POST("/upload-url", idem, s.handleUploadURL)
+	authed.POST("/upload-urls", idem, s.handleBatchUploadURLs)
+	authed.POST("/complete-upload", idem, s.handleCompleteUpload)
+	authed.GET("/media", s.handleListMedia)
+	authed.GET("/media/:id", s.handleGetMedia)
+	authed.DELETE("/media/:id", idem, s.handleDeleteMedia)
+	authed.POST("/media/import", idem, s.handleImport)
+	authed.POST("/media/:id/reprocess", idem, s.handleReprocess)
+	authed.POST("/media/:id/cancel", idem, s.handleCancelMedia)
+	authed.GET("/media/:id/tasks", s.handleListMediaTasks)
+	authed.GET("/media/:id/history", s.handleMediaHistory)
+	authed.GET("/media/:id/events", s.handleMediaEvents)
+	authed.GET("/tasks/:id", s.handleGetTask)
+	authed.GET("/usage", s.handleGetUsage)
+	// Custom methods such as /media:batchGet; gin treats ":..." as a
+	// wildcard, so handleMediaAction dispatches on its value.
+	authed.POST("/media:action", s.handleMediaAction)
 }
 
 func (s *Server) handleUploadURL(c *gin.Context) {
@@ -64,38 +138,117 @@ func (s *Server) handleUploadURL(c *gin.Context) {
 		return
 	}
 
-	if req.ContentType != "" && !strings.HasPrefix(req.ContentType, "image/") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "content_type must be image/*"})
+	if !s.checkStorageQuota(c) {
 		return
 	}
 
-	mediaID := ulid.Make().String()
-	ext := path.Ext(req.FileName)
-	if ext == "" {
-		ext = ".bin"
+	m, resp, rerr := s.prepareUpload(context.Background(), ownerID(c), req)
+	if rerr != nil {
+		c.JSON(rerr.Status, gin.H{"error": rerr.Message})
+		return
 	}
 
-	originalKey := "media/" + mediaID + "/original" + ext
-	expiry := 5 * time.Minute
-
-	uploadURL, err := s.Store.PresignUpload(context.Background(), originalKey, expiry)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to presign"})
+	// The run is charged when the media is created, so completing the
+	// upload cannot exceed the quota.
+	if !s.chargeRuns(c, 1) {
 		return
 	}
-
-	if err := db.InsertMedia(context.Background(), s.DB, mediaID, "INIT", originalKey); err != nil {
+	if err := db.InsertMedia(actorCtx(c), s.DB, *m); err != nil {
+		s.refundRuns(c, 1)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create media"})
 		return
 	}
 	obs.TasksCreated.Inc()
 
-	c.JSON(http.StatusOK, UploadURLResponse{
-		MediaID:     mediaID,
+	c.JSON(http.StatusOK, resp)
+}
+
+// requestError is a client-facing failure with the HTTP status to report.
+type requestError struct {
+	Status  int
+	Message string
+}
+
+// prepareUpload validates req, allocates a new media for owner and presigns
+// the upload of its original. The returned media still has to be inserted.
+func (s *Server) prepareUpload(ctx context.Context, owner string, req UploadURLRequest) (*db.MediaInput, *UploadURLResponse, *requestError) {
+	if req.ContentType != "" && !strings.HasPrefix(req.ContentType, "image/") {
+		return nil, nil, &requestError{http.StatusBadRequest, "content_type must be image/*"}
+	}
+
+	m, rerr := s.newMediaInput(owner, req)
+	if rerr != nil {
+		return nil, nil, rerr
+	}
+
+	expiry := 5 * time.Minute
+	uploadURL, err := s.Store.PresignUpload(ctx, m.OriginalKey, expiry)
+	if err != nil {
+		return nil, nil, &requestError{http.StatusInternalServerError, "failed to presign"}
+	}
+
+	return m, &UploadURLResponse{
+		MediaID:     m.ID,
 		UploadURL:   uploadURL,
-		OriginalKey: originalKey,
+		OriginalKey: m.OriginalKey,
 		ExpiresIn:   int(expiry.Seconds()),
-	})
+	}, nil
+}
+
+// newMediaInput validates the profile, priority and callback of req and
+// allocates the media ID and original key.
+func (s *Server) newMediaInput(owner string, req UploadURLRequest) (*db.MediaInput, *requestError) {
+	var callbackURL *string
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, &requestError{http.StatusBadRequest, "callback_url must be an absolute http(s) URL"}
+		}
+		if fetch.CheckHost(u.Hostname(), s.CallbackAllowCIDRs) != nil {
+			return nil, &requestError{http.StatusBadRequest, "callback_url must not point to a private address"}
+		}
+		callbackURL = &req.CallbackURL
+	}
+
+	if req.Profile == "" {
+		req.Profile = pipeline.DefaultProfile
+	}
+	profile, ok := pipeline.LookupProfile(req.Profile)
+	if !ok {
+		return nil, &requestError{http.StatusBadRequest, "unknown profile"}
+	}
+	priority, rerr := parsePriority(req.Priority, profile.Priority)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	mediaID := ulid.Make().String()
+	ext := path.Ext(req.FileName)
+	if ext == "" {
+		ext = ".bin"
+	}
+
+	return &db.MediaInput{
+		ID:          mediaID,
+		Status:      "INIT",
+		OriginalKey: storage.MediaPrefix(owner, mediaID) + "original" + ext,
+		Profile:     profile.Name,
+		Steps:       profile.Steps,
+		CallbackURL: callbackURL,
+		OwnerID:     owner,
+		Priority:    priority,
+	}, nil
+}
+
+// parsePriority validates an optional request priority, falling back to def.
+func parsePriority(p *int, def int) (int, *requestError) {
+	if p == nil {
+		return def, nil
+	}
+	if *p < 0 || *p > mq.MaxPriority {
+		return 0, &requestError{http.StatusBadRequest, "priority must be between 0 and 9"}
+	}
+	return *p, nil
 }
 
 func (s *Server) handleCompleteUpload(c *gin.Context) {
@@ -109,54 +262,172 @@ func (s *Server) handleCompleteUpload(c *gin.Context) {
 		return
 	}
 
-	if err := db.UpdateMediaStatus(context.Background(), s.DB, req.MediaID, "PROCESSING"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update media"})
+	m, ok := s.getOwnedMedia(c, req.MediaID)
+	if !ok {
+		return
+	}
+	if m.Status == "EXPIRED" {
+		c.JSON(http.StatusGone, gin.H{"error": "upload expired"})
+		return
+	}
+	// A repeated call for media already PROCESSING only recreates a missing
+	// first task; finished or cancelled media has to be reprocessed instead.
+	if m.Status != "INIT" && m.Status != "UPLOADED" && m.Status != "PROCESSING" {
+		c.JSON(http.StatusConflict, gin.H{"error": "media is " + m.Status})
+		return
+	}
+	if req.OriginalKey != m.OriginalKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "original_key does not match media"})
+		return
+	}
+	if !s.admit(c) {
+		return
+	}
+
+	size, exists, err := s.Store.ObjectSize(context.Background(), m.OriginalKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check original"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "original not uploaded"})
 		return
 	}
+	if m.Status != "PROCESSING" {
+		if err := db.MarkMediaUploaded(actorCtx(c), s.DB, m.ID, size); err != nil {
+			if writeStateError(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update media"})
+			return
+		}
+	}
 
-	// Create first task (resize) with deterministic output key.
+	// Create the first task of the pipeline with a deterministic output key;
+	// the scheduler dispatches it and the worker chains the remaining steps.
 	taskID := ulid.Make().String()
-	outputKey := "media/" + req.MediaID + "/resize.jpg"
-	inserted, err := db.InsertProcessingTask(context.Background(), s.DB, db.ProcessingTaskInput{
-		ID:        taskID,
-		MediaID:   req.MediaID,
-		Step:      "resize",
-		Status:    "PENDING",
-		InputKey:  req.OriginalKey,
-		OutputKey: outputKey,
+	step := m.Steps[0]
+	correlationID, traceID := runIDs(c)
+	_, err = db.InsertProcessingTask(actorCtx(c), s.DB, db.ProcessingTaskInput{
+		ID:         taskID,
+		MediaID:    req.MediaID,
+		Generation: m.Generation,
+		Step:       step,
+		Status:     "PENDING",
+		InputKey:   m.OriginalKey,
+		OutputKey:  pipeline.OutputKey(m.TenantID(), req.MediaID, m.Generation, step),
+		Priority:   m.Priority,
+
+		CorrelationID: &correlationID,
+		TraceID:       &traceID,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
 		return
 	}
 
-	if inserted && s.Publisher != nil {
-		_ = s.Publisher.PublishTask(mq.TaskMessage{
-			TaskID:  taskID,
-			MediaID: req.MediaID,
-			Step:    "resize",
-		})
-		obs.TasksPublished.Inc()
-	}
-
 	c.JSON(http.StatusOK, gin.H{"status": "PROCESSING"})
 }
 
 func (s *Server) handleGetMedia(c *gin.Context) {
-	id := c.Param("id")
-	m, err := db.GetMedia(context.Background(), s.DB, id)
+	if c.Query("wait") != "" {
+		s.handleWaitMedia(c)
+		return
+	}
+
+	m, ok := s.getOwnedMedia(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, toMediaResponse(m))
+}
+
+func (s *Server) handleListMedia(c *gin.Context) {
+	f := db.MediaFilter{
+		Profile: c.Query("profile"),
+		OwnerID: ownerID(c),
+		Cursor:  strings.ToUpper(c.Query("cursor")),
+		Limit:   defaultListLimit,
+	}
+
+	if v := c.Query("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			st = strings.ToUpper(strings.TrimSpace(st))
+			if !mediaStatuses[st] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: " + st})
+				return
+			}
+			f.Statuses = append(f.Statuses, st)
+		}
+	}
+	if v := c.Query("created_after"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "created_after must be RFC3339"})
+			return
+		}
+		f.CreatedAfter = t
+	}
+	if v := c.Query("created_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "created_before must be RFC3339"})
+			return
+		}
+		f.CreatedBefore = t
+	}
+	if f.Cursor != "" {
+		if _, err := ulid.ParseStrict(f.Cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		f.Limit = min(n, maxListLimit)
+	}
+
+	// Fetch one extra row to know whether another page exists.
+	pageSize := f.Limit
+	f.Limit++
+	rows, err := db.ListMedia(context.Background(), s.DB, f)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list media"})
 		return
 	}
 
+	resp := ListMediaResponse{Items: make([]MediaResponse, 0, len(rows))}
+	if len(rows) > pageSize {
+		rows = rows[:pageSize]
+		resp.NextCursor = rows[pageSize-1].ID
+	}
+	for _, m := range rows {
+		resp.Items = append(resp.Items, toMediaResponse(m))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func toMediaResponse(m *db.MediaRow) MediaResponse {
 	resp := MediaResponse{
-		MediaID: m.ID,
-		Status:  m.Status,
+		MediaID:    m.ID,
+		Status:     m.Status,
+		Profile:    m.Profile,
+		Generation: m.Generation,
+		Priority:   m.Priority,
+		CreatedAt:  m.CreatedAt,
+		UpdatedAt:  m.UpdatedAt,
 	}
 	if m.FinalKey != nil {
 		resp.FinalURL = *m.FinalKey
 	}
-
-	c.JSON(http.StatusOK, resp)
+	if m.OwnerID != nil {
+		resp.OwnerID = *m.OwnerID
+	}
+	return resp
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+type MediaEventResponse struct {
+	ID      int64           `json:"id"`
+	Kind    string          `json:"kind"`
+	Actor   string          `json:"actor"`
+	Payload json.RawMessage `json:"payload"`
+	At      time.Time       `json:"at"`
+}
+
+type MediaHistoryResponse struct {
+	MediaID   string               `json:"media_id"`
+	Events    []MediaEventResponse `json:"events"`
+	NextAfter int64                `json:"next_after,omitempty"`
+}
+
+// actorCtx attributes db writes made for this request to the caller's
+// tenant in the media history.
+func actorCtx(c *gin.Context) context.Context {
+	return db.WithActor(context.Background(), "tenant:"+ownerID(c))
+}
+
+// handleMediaHistory returns the audit trail of a media, oldest first. Pages
+// continue with ?after=<next_after>.
+func (s *Server) handleMediaHistory(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := s.getOwnedMedia(c, id); !ok {
+		return
+	}
+
+	var after int64
+	if v := c.Query("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a non-negative integer"})
+			return
+		}
+		after = n
+	}
+	limit := defaultHistoryLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	rows, err := db.ListMediaHistory(context.Background(), s.DB, id, after, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list history"})
+		return
+	}
+
+	resp := MediaHistoryResponse{MediaID: id, Events: make([]MediaEventResponse, 0, len(rows))}
+	for _, e := range rows {
+		resp.Events = append(resp.Events, MediaEventResponse{
+			ID:      e.ID,
+			Kind:    e.Kind,
+			Actor:   e.Actor,
+			Payload: e.Payload,
+			At:      e.CreatedAt,
+		})
+	}
+	if len(rows) == limit {
+		resp.NextAfter = rows[len(rows)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+
+	maxIdempotencyKeyLen  = 255
+	maxIdempotentBodySize = 1 << 20
+	// A reservation still in flight after this long is assumed abandoned.
+	idempotencyStaleAfter = time.Minute
+)
+
+// IdempotencyMiddleware makes write endpoints safe to retry. The first request
+// with a given Idempotency-Key is executed and its response stored; replays
+// with the same method, path, query and body get the stored response back,
+// reuse of the key for a different request gets 422, and a replay while the
+// original is still running gets 409. Server errors are not stored so they
+// can be retried. Requests without the header are passed through.
+func IdempotencyMiddleware(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key too long"})
+			return
+		}
+
+		// Read one byte past the limit so an oversized body is refused rather
+		// than truncated into a partial request and fingerprint.
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotentBodySize+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+			return
+		}
+		if len(body) > maxIdempotentBodySize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped per owner so clients cannot collide or read each
+		// other's stored responses.
+		scope := ownerID(c)
+		// The query is part of the request: DELETE /media/:id?purge=true
+		// must not replay a plain delete stored under the same key.
+		method, path := c.Request.Method, c.Request.URL.Path
+		if q := c.Request.URL.RawQuery; q != "" {
+			path += "?" + q
+		}
+		hash := requestFingerprint(method, path, body)
+
+		rec, reserved, err := db.ReserveIdempotencyKey(context.Background(), pool, scope, key, method, path, hash, idempotencyStaleAfter)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check idempotency key"})
+			return
+		}
+		if !reserved {
+			switch {
+			case rec.RequestHash != hash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case rec.StatusCode == nil:
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(*rec.StatusCode, "application/json; charset=utf-8", rec.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError {
+			if err := db.ReleaseIdempotencyKey(context.Background(), pool, scope, key); err != nil {
+				log.Printf("release idempotency key failed: %v", err)
+			}
+			return
+		}
+		if err := db.CompleteIdempotencyKey(context.Background(), pool, scope, key, status, w.body.Bytes()); err != nil {
+			log.Printf("store idempotent response failed: %v", err)
+		}
+	}
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureWriter keeps a copy of the response body for storage.
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+)
+
+type ImportRequest struct {
+	SourceURL   string `json:"source_url"`
+	Profile     string `json:"profile"`
+	CallbackURL string `json:"callback_url"`
+	Priority    *int   `json:"priority"`
+}
+
+type ImportResponse struct {
+	MediaID     string `json:"media_id"`
+	Status      string `json:"status"`
+	OriginalKey string `json:"original_key"`
+}
+
+// handleImport creates media from a source URL instead of a client upload. A
+// worker downloads the source in a "fetch" step, stores it as the original and
+// then continues with the profile's steps. Destination checks (private
+// ranges, redirects, size) happen in the worker at connect time.
+func (s *Server) handleImport(c *gin.Context) {
+	var req ImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	src, err := url.Parse(req.SourceURL)
+	if err != nil || (src.Scheme != "http" && src.Scheme != "https") || src.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source_url must be an absolute http(s) URL"})
+		return
+	}
+	if !s.checkStorageQuota(c) {
+		return
+	}
+	if !s.admit(c) {
+		return
+	}
+
+	m, rerr := s.newMediaInput(ownerID(c), UploadURLRequest{
+		FileName:    src.Path,
+		Profile:     req.Profile,
+		CallbackURL: req.CallbackURL,
+		Priority:    req.Priority,
+	})
+	if rerr != nil {
+		c.JSON(rerr.Status, gin.H{"error": rerr.Message})
+		return
+	}
+	m.Status = "PROCESSING"
+	m.Steps = append([]string{"fetch"}, m.Steps...)
+
+	correlationID, traceID := runIDs(c)
+	task := db.ProcessingTaskInput{
+		ID:         ulid.Make().String(),
+		MediaID:    m.ID,
+		Generation: 1,
+		Step:       "fetch",
+		Status:     "PENDING",
+		InputKey:   src.String(),
+		OutputKey:  m.OriginalKey,
+		Priority:   m.Priority,
+
+		CorrelationID: &correlationID,
+		TraceID:       &traceID,
+	}
+	if !s.chargeRuns(c, 1) {
+		return
+	}
+	if err := db.InsertMediaWithTask(actorCtx(c), s.DB, *m, task); err != nil {
+		s.refundRuns(c, 1)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create media"})
+		return
+	}
+	obs.TasksCreated.Inc()
+
+	c.JSON(http.StatusAccepted, ImportResponse{
+		MediaID:     m.ID,
+		Status:      m.Status,
+		OriginalKey: m.OriginalKey,
+	})
+}
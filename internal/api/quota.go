@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+)
+
+type UsageResponse struct {
+	TenantID               string `json:"tenant_id"`
+	StorageBytes           int64  `json:"storage_bytes"`
+	StorageQuotaBytes      int64  `json:"storage_quota_bytes,omitempty"`
+	MonthlyProcessingRuns  int    `json:"monthly_processing_runs"`
+	MonthlyProcessingQuota int    `json:"monthly_processing_quota,omitempty"`
+}
+
+func (s *Server) handleGetUsage(c *gin.Context) {
+	resp, err := s.tenantUsage(context.Background(), ownerID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get usage"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// tenantUsage returns the usage of tenant together with its effective quotas.
+func (s *Server) tenantUsage(ctx context.Context, tenant string) (*UsageResponse, error) {
+	q, err := db.GetTenantQuota(ctx, s.DB, tenant)
+	if err != nil {
+		return nil, err
+	}
+	u, err := db.GetTenantUsage(ctx, s.DB, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &UsageResponse{
+		TenantID:               tenant,
+		StorageBytes:           u.StorageBytes,
+		StorageQuotaBytes:      s.DefaultStorageQuota,
+		MonthlyProcessingRuns:  u.MonthlyProcessRuns,
+		MonthlyProcessingQuota: s.DefaultMonthlyQuota,
+	}
+	if q.StorageBytes != nil {
+		resp.StorageQuotaBytes = *q.StorageBytes
+	}
+	if q.MonthlyProcessRuns != nil {
+		resp.MonthlyProcessingQuota = *q.MonthlyProcessRuns
+	}
+	return resp, nil
+}
+
+// checkStorageQuota reports whether the caller's tenant may store more
+// originals. A full storage quota is refused with 403, since it only clears
+// once media is deleted.
+func (s *Server) checkStorageQuota(c *gin.Context) bool {
+	u, err := s.tenantUsage(context.Background(), ownerID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return false
+	}
+	if u.StorageQuotaBytes > 0 && u.StorageBytes >= u.StorageQuotaBytes {
+		obs.QuotaRejections.WithLabelValues("storage").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "storage quota exceeded"})
+		return false
+	}
+	return true
+}
+
+// chargeRuns counts n pipeline runs against the caller's monthly quota. Runs
+// that do not fit are refused with 429 and a Retry-After pointing at the
+// start of next month. A request that fails after the charge gives the runs
+// back with refundRuns.
+func (s *Server) chargeRuns(c *gin.Context, n int) bool {
+	ok, err := db.ChargeProcessingRuns(context.Background(), s.DB, ownerID(c), n, s.DefaultMonthlyQuota)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return false
+	}
+	if !ok {
+		obs.QuotaRejections.WithLabelValues("monthly_processing").Inc()
+		now := time.Now().UTC()
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		c.Header("Retry-After", strconv.Itoa(int(nextMonth.Sub(now).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "monthly processing quota exceeded"})
+		return false
+	}
+	return true
+}
+
+// refundRuns gives back runs charged for a request that then failed. A
+// failure only over-counts, so it is logged rather than reported.
+func (s *Server) refundRuns(c *gin.Context, n int) {
+	if err := db.RefundProcessingRuns(context.Background(), s.DB, ownerID(c), n); err != nil {
+		log.Printf("refund %d processing runs for %s failed: %v", n, ownerID(c), err)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	lim := RateLimit{Rate: 2, Burst: 3}
+	t0 := time.Unix(1_700_000_000, 0)
+
+	type take struct {
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}
+	for _, tc := range []struct {
+		name  string
+		takes []take
+	}{
+		{"burst then reject", []take{
+			{0, true, 2, 500 * time.Millisecond, 0},
+			{0, true, 1, time.Second, 0},
+			{0, true, 0, 1500 * time.Millisecond, 0},
+			{0, false, 0, 1500 * time.Millisecond, 500 * time.Millisecond},
+		}},
+		{"refill", []take{
+			{0, true, 2, 500 * time.Millisecond, 0},
+			{0, true, 1, time.Second, 0},
+			{0, true, 0, 1500 * time.Millisecond, 0},
+			{250 * time.Millisecond, false, 0, 1250 * time.Millisecond, 250 * time.Millisecond},
+			{500 * time.Millisecond, true, 0, 1500 * time.Millisecond, 0},
+		}},
+		{"refill caps at burst", []take{
+			{0, true, 2, 500 * time.Millisecond, 0},
+			{time.Hour, true, 2, 500 * time.Millisecond, 0},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewRateLimiter(map[string]RateLimit{"default": lim})
+			for i, want := range tc.takes {
+				allowed, remaining, reset, retryAfter := l.take("k", lim, t0.Add(want.at))
+				if allowed != want.allowed || remaining != want.remaining || reset != want.reset || retryAfter != want.retryAfter {
+					t.Errorf("take %d = %v, %d, %v, %v; want %v, %d, %v, %v", i,
+						allowed, remaining, reset, retryAfter,
+						want.allowed, want.remaining, want.reset, want.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterTakeSeparatesKeys(t *testing.T) {
+	lim := RateLimit{Rate: 1, Burst: 1}
+	l := NewRateLimiter(map[string]RateLimit{"default": lim})
+	now := time.Unix(1_700_000_000, 0)
+
+	if allowed, _, _, _ := l.take("a", lim, now); !allowed {
+		t.Fatal("first take for a rejected")
+	}
+	if allowed, _, _, _ := l.take("a", lim, now); allowed {
+		t.Fatal("second take for a allowed")
+	}
+	if allowed, _, _, _ := l.take("b", lim, now); !allowed {
+		t.Fatal("b shares a's bucket")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	// The slowest policy refills in 10s, so buckets idle longer are dropped.
+	l := NewRateLimiter(map[string]RateLimit{
+		"fast": {Rate: 10, Burst: 10},
+		"slow": {Rate: 1, Burst: 10},
+	})
+	t0 := time.Unix(1_700_000_000, 0)
+
+	l.take("old", l.Policies["slow"], t0)
+	l.take("recent", l.Policies["slow"], t0.Add(55*time.Second))
+
+	// Sweeps run at most once a minute, counted from the first take.
+	l.take("other", l.Policies["fast"], t0.Add(59*time.Second))
+	if _, ok := l.buckets["old"]; !ok {
+		t.Fatal("bucket swept before a minute passed")
+	}
+
+	l.take("other", l.Policies["fast"], t0.Add(61*time.Second))
+	if _, ok := l.buckets["old"]; ok {
+		t.Error("idle bucket kept")
+	}
+	if _, ok := l.buckets["recent"]; !ok {
+		t.Error("bucket idle for less than a refill dropped")
+	}
+}
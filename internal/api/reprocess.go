@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+	"sys-design/internal/pipeline"
+)
+
+type ReprocessRequest struct {
+	Profile  string   `json:"profile"`
+	Steps    []string `json:"steps"`
+	Priority *int     `json:"priority"`
+}
+
+type ReprocessResponse struct {
+	MediaID    string   `json:"media_id"`
+	Generation int      `json:"generation"`
+	Profile    string   `json:"profile"`
+	Steps      []string `json:"steps"`
+	Priority   int      `json:"priority"`
+}
+
+// handleReprocess starts a new generation of tasks for media that has finished
+// or been cancelled. Outputs of the new generation are written under versioned keys,
+// so earlier outputs neither block the run nor get overwritten.
+func (s *Server) handleReprocess(c *gin.Context) {
+	id := c.Param("id")
+
+	var req ReprocessRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			return
+		}
+	}
+
+	m, ok := s.getOwnedMedia(c, id)
+	if !ok {
+		return
+	}
+	if m.Status != "READY" && m.Status != "FAILED" && m.Status != "CANCELLED" {
+		c.JSON(http.StatusConflict, gin.H{"error": "media is " + m.Status})
+		return
+	}
+	if !s.admit(c) {
+		return
+	}
+
+	if req.Profile == "" {
+		req.Profile = m.Profile
+	}
+	profile, ok := pipeline.LookupProfile(req.Profile)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown profile"})
+		return
+	}
+	steps, err := profile.SelectSteps(req.Steps)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Reprocessing is typically a bulk backfill, so it yields to uploads
+	// unless the caller asks otherwise.
+	priority, rerr := parsePriority(req.Priority, pipeline.PriorityBulk)
+	if rerr != nil {
+		c.JSON(rerr.Status, gin.H{"error": rerr.Message})
+		return
+	}
+
+	if !s.checkStorageQuota(c) || !s.chargeRuns(c, 1) {
+		return
+	}
+
+	generation := m.Generation + 1
+	correlationID, traceID := runIDs(c)
+	first := db.ProcessingTaskInput{
+		ID:         ulid.Make().String(),
+		MediaID:    id,
+		Generation: generation,
+		Step:       steps[0],
+		Status:     "PENDING",
+		InputKey:   m.OriginalKey,
+		OutputKey:  pipeline.OutputKey(m.TenantID(), id, generation, steps[0]),
+		Priority:   priority,
+
+		CorrelationID: &correlationID,
+		TraceID:       &traceID,
+	}
+	err = db.StartGeneration(actorCtx(c), s.DB, db.GenerationInput{
+		MediaID:        id,
+		FromGeneration: m.Generation,
+		Profile:        profile.Name,
+		Steps:          steps,
+		Priority:       priority,
+		FirstTask:      first,
+	})
+	if err != nil {
+		s.refundRuns(c, 1)
+	}
+	if writeStateError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start reprocess"})
+		return
+	}
+	obs.TasksCreated.Inc()
+
+	c.JSON(http.StatusAccepted, ReprocessResponse{
+		MediaID:    id,
+		Generation: generation,
+		Profile:    profile.Name,
+		Steps:      steps,
+		Priority:   priority,
+	})
+}
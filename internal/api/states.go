@@ -0,0 +1,25 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+// writeStateError answers 409 for a write the media state machine refused
+// and reports whether err was such a refusal.
+func writeStateError(c *gin.Context, err error) bool {
+	var te *db.TransitionError
+	switch {
+	case errors.As(err, &te):
+		c.JSON(http.StatusConflict, gin.H{"error": "media is " + te.From})
+	case errors.Is(err, db.ErrStaleGeneration):
+		c.JSON(http.StatusConflict, gin.H{"error": "media changed, retry"})
+	default:
+		return false
+	}
+	return true
+}
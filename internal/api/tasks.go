@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+type TaskResponse struct {
+	TaskID     string     `json:"task_id"`
+	MediaID    string     `json:"media_id"`
+	Generation int        `json:"generation"`
+	Step       string     `json:"step"`
+	Status     string     `json:"status"`
+	Priority   int        `json:"priority"`
+	RetryCount int        `json:"retry_count"`
+	LockBy     string     `json:"lock_by,omitempty"`
+	LockUntil  *time.Time `json:"lock_until,omitempty"`
+	LeaseEpoch int64      `json:"lease_epoch"`
+	InputKey   string     `json:"input_key"`
+	OutputKey  string     `json:"output_key"`
+	LastError  string     `json:"last_error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	CorrelationID string `json:"correlation_id,omitempty"`
+	TraceID       string `json:"trace_id,omitempty"`
+
+	// Attempts is only filled in by GET /tasks/:id.
+	Attempts []TaskAttemptResponse `json:"attempts,omitempty"`
+}
+
+type TaskAttemptResponse struct {
+	Attempt    int        `json:"attempt"`
+	WorkerID   string     `json:"worker_id"`
+	LeaseEpoch *int64     `json:"lease_epoch,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMS *int64     `json:"duration_ms,omitempty"`
+	Outcome    string     `json:"outcome,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type ListTasksResponse struct {
+	MediaID string         `json:"media_id"`
+	Tasks   []TaskResponse `json:"tasks"`
+}
+
+func (s *Server) handleListMediaTasks(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := s.getOwnedMedia(c, id); !ok {
+		return
+	}
+
+	rows, err := db.ListTasksByMedia(context.Background(), s.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tasks"})
+		return
+	}
+
+	resp := ListTasksResponse{MediaID: id, Tasks: make([]TaskResponse, 0, len(rows))}
+	for _, t := range rows {
+		resp.Tasks = append(resp.Tasks, toTaskResponse(t))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetTask(c *gin.Context) {
+	t, err := db.GetTask(context.Background(), s.DB, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if _, ok := s.getOwnedMedia(c, t.MediaID); !ok {
+		return
+	}
+
+	attempts, err := db.ListTaskAttempts(context.Background(), s.DB, t.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list attempts"})
+		return
+	}
+	resp := toTaskResponse(t)
+	resp.Attempts = make([]TaskAttemptResponse, 0, len(attempts))
+	for _, a := range attempts {
+		resp.Attempts = append(resp.Attempts, toTaskAttemptResponse(a))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func toTaskResponse(t *db.ProcessingTaskRow) TaskResponse {
+	resp := TaskResponse{
+		TaskID:     t.ID,
+		MediaID:    t.MediaID,
+		Generation: t.Generation,
+		Step:       t.Step,
+		Status:     t.Status,
+		Priority:   t.Priority,
+		RetryCount: t.RetryCount,
+		LockUntil:  t.LockUntil,
+		LeaseEpoch: t.LeaseEpoch,
+		InputKey:   t.InputKey,
+		OutputKey:  t.OutputKey,
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+	}
+	if t.LockBy != nil {
+		resp.LockBy = *t.LockBy
+	}
+	if t.LastError != nil {
+		resp.LastError = *t.LastError
+	}
+	if t.CorrelationID != nil {
+		resp.CorrelationID = *t.CorrelationID
+	}
+	if t.TraceID != nil {
+		resp.TraceID = *t.TraceID
+	}
+	return resp
+}
+
+func toTaskAttemptResponse(a *db.TaskAttemptRow) TaskAttemptResponse {
+	resp := TaskAttemptResponse{
+		Attempt:    a.Attempt,
+		WorkerID:   a.WorkerID,
+		LeaseEpoch: a.LeaseEpoch,
+		StartedAt:  a.StartedAt,
+		FinishedAt: a.FinishedAt,
+	}
+	if a.FinishedAt != nil {
+		ms := a.FinishedAt.Sub(a.StartedAt).Milliseconds()
+		resp.DurationMS = &ms
+	}
+	if a.Outcome != nil {
+		resp.Outcome = *a.Outcome
+	}
+	if a.Error != nil {
+		resp.Error = *a.Error
+	}
+	return resp
+}
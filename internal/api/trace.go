@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+)
+
+const maxCorrelationIDLen = 128
+
+// runIDs returns the correlation and trace IDs for a pipeline run started by
+// this request. The correlation ID is the client's X-Correlation-ID, the
+// trace ID that of a W3C traceparent header; either is generated when
+// missing or invalid. The correlation ID is echoed in the response.
+func runIDs(c *gin.Context) (correlationID, traceID string) {
+	correlationID = strings.TrimSpace(c.GetHeader("X-Correlation-ID"))
+	if correlationID == "" || len(correlationID) > maxCorrelationIDLen || strings.ContainsFunc(correlationID, func(r rune) bool { return r < 0x21 || r > 0x7e }) {
+		correlationID = ulid.Make().String()
+	}
+	c.Header("X-Correlation-ID", correlationID)
+
+	traceID = parseTraceparent(c.GetHeader("traceparent"))
+	if traceID == "" {
+		var b [16]byte
+		_, _ = rand.Read(b[:])
+		traceID = hex.EncodeToString(b[:])
+	}
+	return correlationID, traceID
+}
+
+// parseTraceparent returns the trace ID of a "00-<trace-id>-<parent-id>-<flags>"
+// header, or "" if the header is not valid.
+func parseTraceparent(h string) string {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	id := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(id); err != nil || id == strings.Repeat("0", 32) {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sys-design/internal/db"
+)
+
+// handleWaitMedia long-polls GET /media/:id?wait=30s[&until=READY,FAILED].
+// It answers as soon as the media is in one of the until statuses (default:
+// any terminal status) or in a terminal status it can no longer leave, or
+// with the current state once the wait elapses. The wait is capped at
+// MaxWait. Wake-ups come from the events hub, not from polling the database.
+func (s *Server) handleWaitMedia(c *gin.Context) {
+	id := c.Param("id")
+
+	wait, err := time.ParseDuration(c.Query("wait"))
+	if err != nil || wait < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a duration like 30s"})
+		return
+	}
+	wait = min(wait, s.MaxWait)
+
+	until := map[string]bool{}
+	if v := c.Query("until"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			st = strings.ToUpper(strings.TrimSpace(st))
+			if !mediaStatuses[st] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: " + st})
+				return
+			}
+			until[st] = true
+		}
+	}
+	done := func(status string) bool {
+		return until[status] || isTerminalMediaStatus(status)
+	}
+
+	events, resync, unsubscribe := s.Events.Subscribe(id)
+	defer unsubscribe()
+
+	m, ok := s.getOwnedMedia(c, id)
+	if !ok {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for !done(m.Status) {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timer.C:
+			c.JSON(http.StatusOK, toMediaResponse(m))
+			return
+		case ev := <-events:
+			if ev.Type != db.EventTypeMedia {
+				continue
+			}
+			if ev.Status == "DELETED" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			if m, err = db.GetMedia(context.Background(), s.DB, id); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+		case <-resync:
+			// A status change may have been missed.
+			if m, err = db.GetMedia(context.Background(), s.DB, id); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, toMediaResponse(m))
+}
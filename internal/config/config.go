@@ -7,18 +7,21 @@ import (
 )
 
 type Config struct {
-	APIPort string
+	APIPort        string
+	TrustedProxies string
 
 	PostgresHost     string
 	PostgresPort     string
 	PostgresUser     string
 	PostgresPassword string
 	PostgresDB       string
+	MigrateOnStart   bool
 
 	RabbitHost     string
 	RabbitPort     string
 	RabbitUser     string
 	RabbitPassword string
+	RabbitExchange string
 	RabbitQueue    string
 
 	MinioEndpoint  string
@@ -29,26 +32,70 @@ type Config struct {
 	MinioRegion    string
 	MinioUseSSL    bool
 
-	TaskLeaseSeconds int
-	TaskMaxRetries   int
-	WorkerMetricsPort string
+	TaskLeaseSeconds       int
+	TaskMaxRetries         int
+	WorkerMetricsPort      string
+	SweeperIntervalSeconds int
+
+	SchedulerIntervalMillis   int
+	SchedulerMaxQueuedPerStep int
+	DispatchTimeoutSeconds    int
+
+	UploadTTLSeconds       int
+	JanitorIntervalSeconds int
+	JanitorBatchSize       int
+	DeleteGraceSeconds     int
+	IdempotencyTTLSeconds  int
+
+	WebhookSecret          string
+	WebhookAllowCIDRs      string
+	WebhookMaxAttempts     int
+	WebhookTimeoutSeconds  int
+	WebhookIntervalSeconds int
+
+	FetchTimeoutSeconds int
+	FetchMaxBytes       int64
+	FetchMaxRedirects   int
+	FetchAllowCIDRs     string
+
+	TenantStorageQuotaBytes      int64
+	TenantMonthlyProcessingQuota int
+	TenantMaxInFlight            int
+
+	RateLimitIPRPS          float64
+	RateLimitIPBurst        int
+	RateLimitDefaultRPS     float64
+	RateLimitDefaultBurst   int
+	RateLimitPresignRPS     float64
+	RateLimitPresignBurst   int
+	RateLimitReprocessRPS   float64
+	RateLimitReprocessBurst int
+
+	AdmissionMaxBacklog      int
+	AdmissionIntervalSeconds int
 }
 
 func Load() (*Config, error) {
 	cfg := &Config{}
 
 	cfg.APIPort = getEnv("API_PORT", "8080")
+	// Comma-separated proxy CIDRs whose X-Forwarded-For is believed; empty
+	// trusts none, so the client IP is the connection's peer.
+	cfg.TrustedProxies = getEnv("TRUSTED_PROXIES", "")
 
 	cfg.PostgresHost = getEnv("POSTGRES_HOST", "postgres")
 	cfg.PostgresPort = getEnv("POSTGRES_PORT", "5432")
 	cfg.PostgresUser = getEnv("POSTGRES_USER", "app")
 	cfg.PostgresPassword = getEnv("POSTGRES_PASSWORD", "app")
 	cfg.PostgresDB = getEnv("POSTGRES_DB", "app")
+	cfg.MigrateOnStart = getEnvBool("MIGRATE_ON_START", false)
 
 	cfg.RabbitHost = getEnv("RABBITMQ_HOST", "rabbitmq")
 	cfg.RabbitPort = getEnv("RABBITMQ_PORT", "5672")
 	cfg.RabbitUser = getEnv("RABBITMQ_USER", "guest")
 	cfg.RabbitPassword = getEnv("RABBITMQ_PASSWORD", "guest")
+	cfg.RabbitExchange = getEnv("RABBITMQ_EXCHANGE", "processing")
+	// Prefix of the per-step queues, e.g. processing_tasks.resize.
 	cfg.RabbitQueue = getEnv("RABBITMQ_QUEUE", "processing_tasks")
 
 	cfg.MinioEndpoint = getEnv("MINIO_ENDPOINT", "http://minio:9000")
@@ -62,6 +109,62 @@ func Load() (*Config, error) {
 	cfg.TaskLeaseSeconds = getEnvInt("TASK_LEASE_SECONDS", 60)
 	cfg.TaskMaxRetries = getEnvInt("TASK_MAX_RETRIES", 4)
 	cfg.WorkerMetricsPort = getEnv("WORKER_METRICS_PORT", "9091")
+	cfg.SweeperIntervalSeconds = getEnvInt("SWEEPER_INTERVAL_SECONDS", 5)
+	cfg.SchedulerIntervalMillis = getEnvInt("SCHEDULER_INTERVAL_MS", 250)
+	cfg.SchedulerMaxQueuedPerStep = getEnvInt("SCHEDULER_MAX_QUEUED_PER_STEP", 20)
+	cfg.DispatchTimeoutSeconds = getEnvInt("DISPATCH_TIMEOUT_SECONDS", 600)
+
+	cfg.UploadTTLSeconds = getEnvInt("UPLOAD_TTL_SECONDS", 3600)
+	cfg.JanitorIntervalSeconds = getEnvInt("JANITOR_INTERVAL_SECONDS", 60)
+	cfg.JanitorBatchSize = getEnvInt("JANITOR_BATCH_SIZE", 100)
+	cfg.DeleteGraceSeconds = getEnvInt("DELETE_GRACE_SECONDS", 7*24*3600)
+	cfg.IdempotencyTTLSeconds = getEnvInt("IDEMPOTENCY_TTL_SECONDS", 24*3600)
+
+	// No default: a well-known secret would let anyone forge deliveries.
+	// Binaries that sign webhooks refuse to start without it.
+	cfg.WebhookSecret = getEnv("WEBHOOK_SECRET", "")
+	cfg.WebhookAllowCIDRs = getEnv("WEBHOOK_ALLOW_CIDRS", "")
+	cfg.WebhookMaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	cfg.WebhookTimeoutSeconds = getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 5)
+	cfg.WebhookIntervalSeconds = getEnvInt("WEBHOOK_INTERVAL_SECONDS", 2)
+
+	cfg.FetchTimeoutSeconds = getEnvInt("FETCH_TIMEOUT_SECONDS", 30)
+	cfg.FetchMaxBytes = int64(getEnvInt("FETCH_MAX_BYTES", 20<<20))
+	cfg.FetchMaxRedirects = getEnvInt("FETCH_MAX_REDIRECTS", 3)
+	cfg.FetchAllowCIDRs = getEnv("FETCH_ALLOW_CIDRS", "")
+
+	cfg.TenantStorageQuotaBytes = int64(getEnvInt("TENANT_STORAGE_QUOTA_BYTES", 0))
+	cfg.TenantMonthlyProcessingQuota = getEnvInt("TENANT_MONTHLY_PROCESSING_QUOTA", 0)
+	cfg.TenantMaxInFlight = getEnvInt("TENANT_MAX_IN_FLIGHT", 0)
+
+	cfg.RateLimitIPRPS = getEnvFloat("RATE_LIMIT_IP_RPS", 50)
+	cfg.RateLimitIPBurst = getEnvInt("RATE_LIMIT_IP_BURST", 100)
+	cfg.RateLimitDefaultRPS = getEnvFloat("RATE_LIMIT_DEFAULT_RPS", 10)
+	cfg.RateLimitDefaultBurst = getEnvInt("RATE_LIMIT_DEFAULT_BURST", 20)
+	cfg.RateLimitPresignRPS = getEnvFloat("RATE_LIMIT_PRESIGN_RPS", 20)
+	cfg.RateLimitPresignBurst = getEnvInt("RATE_LIMIT_PRESIGN_BURST", 100)
+	cfg.RateLimitReprocessRPS = getEnvFloat("RATE_LIMIT_REPROCESS_RPS", 0.5)
+	cfg.RateLimitReprocessBurst = getEnvInt("RATE_LIMIT_REPROCESS_BURST", 5)
+
+	cfg.AdmissionMaxBacklog = getEnvInt("ADMISSION_MAX_BACKLOG", 5000)
+	cfg.AdmissionIntervalSeconds = getEnvInt("ADMISSION_INTERVAL_SECONDS", 5)
+
+	// Intervals drive tickers, which panic on a non-positive period.
+	for _, v := range []struct {
+		key   string
+		value int
+	}{
+		{"SWEEPER_INTERVAL_SECONDS", cfg.SweeperIntervalSeconds},
+		{"SCHEDULER_INTERVAL_MS", cfg.SchedulerIntervalMillis},
+		{"DISPATCH_TIMEOUT_SECONDS", cfg.DispatchTimeoutSeconds},
+		{"JANITOR_INTERVAL_SECONDS", cfg.JanitorIntervalSeconds},
+		{"WEBHOOK_INTERVAL_SECONDS", cfg.WebhookIntervalSeconds},
+		{"ADMISSION_INTERVAL_SECONDS", cfg.AdmissionIntervalSeconds},
+	} {
+		if v.value <= 0 {
+			return nil, fmt.Errorf("config: %s must be positive, got %d", v.key, v.value)
+		}
+	}
 
 	return cfg, nil
 }
@@ -114,3 +217,14 @@ func getEnvBool(key string, def bool) bool {
 	}
 	return def
 }
+
+func getEnvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return def
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRow struct {
+	ID        string
+	OwnerID   string
+	Name      string
+	CreatedAt time.Time
+}
+
+func InsertAPIKey(ctx context.Context, pool *pgxpool.Pool, id, ownerID, name, keyHash string) error {
+	_, err := pool.Exec(ctx,
+		"INSERT INTO api_key (id, owner_id, name, key_hash) VALUES ($1, $2, $3, $4)",
+		id, ownerID, name, keyHash,
+	)
+	return err
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash.
+func GetAPIKeyByHash(ctx context.Context, pool *pgxpool.Pool, keyHash string) (*APIKeyRow, error) {
+	k := &APIKeyRow{}
+	err := pool.QueryRow(ctx,
+		"SELECT id, owner_id, name, created_at FROM api_key WHERE key_hash = $1 AND revoked_at IS NULL",
+		keyHash,
+	).Scan(&k.ID, &k.OwnerID, &k.Name, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// RevokeAPIKey reports whether an active key was revoked.
+func RevokeAPIKey(ctx context.Context, pool *pgxpool.Pool, id string) (bool, error) {
+	cmd, err := pool.Exec(ctx,
+		"UPDATE api_key SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() == 1, nil
+}
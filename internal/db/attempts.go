@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TaskAttemptRow struct {
+	ID         int64
+	TaskID     string
+	Attempt    int
+	WorkerID   string
+	LeaseEpoch *int64
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Outcome    *string
+	Error      *string
+}
+
+// StartTaskAttempt records that workerID claimed the task under epoch and
+// returns the attempt's ID. The attempt number is the task's retry count plus
+// one.
+func StartTaskAttempt(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, epoch int64) (int64, error) {
+	var id int64
+	err := pool.QueryRow(ctx,
+		"INSERT INTO task_attempt (task_id, attempt, worker_id, lease_epoch) SELECT id, retry_count + 1, $2, $3 FROM processing_task WHERE id = $1 RETURNING id",
+		taskID, workerID, epoch,
+	).Scan(&id)
+	return id, err
+}
+
+// FinishTaskAttempt records how an attempt ended. errMsg is stored as NULL
+// when empty.
+func FinishTaskAttempt(ctx context.Context, pool *pgxpool.Pool, attemptID int64, outcome string, errMsg string) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE task_attempt SET finished_at = NOW(), outcome = $2, error = NULLIF($3, '') WHERE id = $1 AND finished_at IS NULL",
+		attemptID, outcome, errMsg,
+	)
+	return err
+}
+
+// ListTaskAttempts returns the attempts of a task, oldest first.
+func ListTaskAttempts(ctx context.Context, pool *pgxpool.Pool, taskID string) ([]*TaskAttemptRow, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT id, task_id, attempt, worker_id, lease_epoch, started_at, finished_at, outcome, error FROM task_attempt WHERE task_id = $1 ORDER BY id",
+		taskID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*TaskAttemptRow
+	for rows.Next() {
+		var a TaskAttemptRow
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.Attempt, &a.WorkerID, &a.LeaseEpoch, &a.StartedAt, &a.FinishedAt, &a.Outcome, &a.Error); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &a)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// EventsChannel is the Postgres NOTIFY channel that media and task state
+// changes are published on.
+const EventsChannel = "media_events"
+
+const (
+	EventTypeMedia = "media"
+	EventTypeTask  = "task"
+)
+
+// MediaEvent describes one media or task state change. Task events carry the
+// step, the worker holding the lease and the last error.
+type MediaEvent struct {
+	Type       string    `json:"type"`
+	MediaID    string    `json:"media_id"`
+	Status     string    `json:"status"`
+	Generation int       `json:"generation,omitempty"`
+	TaskID     string    `json:"task_id,omitempty"`
+	Step       string    `json:"step,omitempty"`
+	WorkerID   string    `json:"worker_id,omitempty"`
+	RetryCount int       `json:"retry_count,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// NOTIFY payloads are limited to 8000 bytes.
+const maxEventErrorLen = 1024
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// notify publishes ev on EventsChannel. Inside a transaction the notification
+// is only delivered on commit.
+func notify(ctx context.Context, q querier, ev MediaEvent) error {
+	ev.At = time.Now().UTC()
+	if len(ev.Error) > maxEventErrorLen {
+		ev.Error = ev.Error[:maxEventErrorLen]
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, "SELECT pg_notify($1, $2)", EventsChannel, string(payload))
+	return err
+}
+
+func notifyMedia(ctx context.Context, q querier, mediaID string, status string, generation int) error {
+	return notify(ctx, q, MediaEvent{Type: EventTypeMedia, MediaID: mediaID, Status: status, Generation: generation})
+}
+
+// taskEventReturning is appended to single-row task writes so execTaskEvent
+// can build the event without a second query.
+const taskEventReturning = " RETURNING id, media_id, generation, step, status, retry_count, lock_by, last_error, lease_epoch"
+
+// execTaskEvent runs a task write ending in taskEventReturning, records it in
+// the media history as kind within the same statement and publishes the
+// resulting task event. It reports whether a row was written. Event delivery
+// is best effort: the write has already happened.
+func execTaskEvent(ctx context.Context, q querier, kind string, query string, args ...any) (bool, error) {
+	_, ok, err := queryTaskEvent(ctx, q, kind, query, args...)
+	return ok, err
+}
+
+// queryTaskEvent is execTaskEvent that also returns the written row's lease
+// epoch.
+func queryTaskEvent(ctx context.Context, q querier, kind string, query string, args ...any) (int64, bool, error) {
+	var (
+		ev        = MediaEvent{Type: EventTypeTask}
+		lockBy    *string
+		lastError *string
+		epoch     int64
+	)
+	n := len(args)
+	err := q.QueryRow(ctx,
+		fmt.Sprintf(`WITH t AS (%s%s), h AS (
+			INSERT INTO media_event (media_id, kind, actor, payload)
+			SELECT media_id, $%d::text, $%d::text, jsonb_strip_nulls(jsonb_build_object('task_id', id, 'generation', generation, 'step', step, 'status', status, 'retry_count', retry_count, 'worker_id', lock_by, 'lease_epoch', NULLIF(lease_epoch, 0), 'error', last_error)) FROM t
+		)
+		SELECT * FROM t`, query, taskEventReturning, n+1, n+2),
+		append(args, kind, actorFrom(ctx))...,
+	).Scan(&ev.TaskID, &ev.MediaID, &ev.Generation, &ev.Step, &ev.Status, &ev.RetryCount, &lockBy, &lastError, &epoch)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if lockBy != nil {
+		ev.WorkerID = *lockBy
+	}
+	if lastError != nil {
+		ev.Error = *lastError
+	}
+	_ = notify(ctx, q, ev)
+	return epoch, true, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kinds of media_event rows. Task kinds carry the task, step and generation
+// in their payload.
+const (
+	HistoryMediaCreated     = "media.created"
+	HistoryMediaUploaded    = "media.uploaded"
+	HistoryMediaReprocessed = "media.reprocessed"
+	HistoryMediaReady       = "media.ready"
+	HistoryMediaFailed      = "media.failed"
+	HistoryMediaCancelled   = "media.cancelled"
+	HistoryMediaExpired     = "media.expired"
+	HistoryMediaDeleted     = "media.deleted"
+	HistoryMediaPurged      = "media.purged"
+	HistoryTaskQueued       = "task.queued"
+	HistoryTaskStarted      = "task.started"
+	HistoryTaskSucceeded    = "task.succeeded"
+	HistoryTaskRetrying     = "task.retrying"
+	HistoryTaskFailed       = "task.failed"
+	HistoryTaskLeaseExpired = "task.lease_expired"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are attributed to actor in the
+// media history, e.g. "tenant:alice" or "worker:host-1".
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "system"
+}
+
+// recordHistory appends a media_event row. Callers run it in the transaction
+// of the change it records.
+func recordHistory(ctx context.Context, q querier, mediaID string, kind string, payload map[string]any) error {
+	if payload == nil {
+		payload = map[string]any{}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx,
+		"INSERT INTO media_event (media_id, kind, actor, payload) VALUES ($1, $2, $3, $4)",
+		mediaID, kind, actorFrom(ctx), body,
+	)
+	return err
+}
+
+type MediaEventRow struct {
+	ID        int64
+	MediaID   string
+	Kind      string
+	Actor     string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// ListMediaHistory returns up to limit events of a media with an ID above
+// afterID, oldest first.
+func ListMediaHistory(ctx context.Context, pool *pgxpool.Pool, mediaID string, afterID int64, limit int) ([]*MediaEventRow, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT id, media_id, kind, actor, payload, created_at FROM media_event WHERE media_id = $1 AND id > $2 ORDER BY id LIMIT $3",
+		mediaID, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*MediaEventRow
+	for rows.Next() {
+		var e MediaEventRow
+		if err := rows.Scan(&e.ID, &e.MediaID, &e.Kind, &e.Actor, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
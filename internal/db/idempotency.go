@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdempotencyRecord struct {
+	Method       string
+	Path         string
+	RequestHash  string
+	StatusCode   *int
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// ReserveIdempotencyKey claims key for a new request. If the key is already
+// taken it returns the existing record and false. An in-flight reservation
+// older than staleAfter is assumed abandoned (e.g. the API crashed) and is
+// taken over.
+func ReserveIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, scope, key, method, path, requestHash string, staleAfter time.Duration) (*IdempotencyRecord, bool, error) {
+	cmd, err := pool.Exec(ctx,
+		`INSERT INTO idempotency_key (scope, key, method, path, request_hash) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, key) DO UPDATE SET method = EXCLUDED.method, path = EXCLUDED.path, request_hash = EXCLUDED.request_hash, created_at = NOW()
+		WHERE idempotency_key.status_code IS NULL AND idempotency_key.created_at < NOW() - ($6 * INTERVAL '1 second')`,
+		scope, key, method, path, requestHash, int(staleAfter.Seconds()),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if cmd.RowsAffected() == 1 {
+		return nil, true, nil
+	}
+
+	rec := &IdempotencyRecord{}
+	err = pool.QueryRow(ctx,
+		"SELECT method, path, request_hash, status_code, response_body, created_at FROM idempotency_key WHERE scope = $1 AND key = $2",
+		scope, key,
+	).Scan(&rec.Method, &rec.Path, &rec.RequestHash, &rec.StatusCode, &rec.ResponseBody, &rec.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return rec, false, nil
+}
+
+func CompleteIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, scope, key string, statusCode int, body []byte) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE idempotency_key SET status_code = $3, response_body = $4, completed_at = NOW() WHERE scope = $1 AND key = $2",
+		scope, key, statusCode, body,
+	)
+	return err
+}
+
+// ReleaseIdempotencyKey drops a reservation whose request failed with a
+// server error, so the client may retry with the same key.
+func ReleaseIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, scope, key string) error {
+	_, err := pool.Exec(ctx,
+		"DELETE FROM idempotency_key WHERE scope = $1 AND key = $2 AND status_code IS NULL",
+		scope, key,
+	)
+	return err
+}
+
+func DeleteExpiredIdempotencyKeys(ctx context.Context, pool *pgxpool.Pool, ttl time.Duration) (int64, error) {
+	cmd, err := pool.Exec(ctx,
+		"DELETE FROM idempotency_key WHERE created_at < NOW() - ($1 * INTERVAL '1 second')",
+		int(ttl.Seconds()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return cmd.RowsAffected(), nil
+}
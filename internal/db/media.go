@@ -2,24 +2,122 @@ package db
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func InsertMedia(ctx context.Context, pool *pgxpool.Pool, id string, status string, originalKey string) error {
-	_, err := pool.Exec(ctx,
-		"INSERT INTO media (id, status, original_key) VALUES ($1, $2, $3)",
-		id, status, originalKey,
+type MediaInput struct {
+	ID          string
+	Status      string
+	OriginalKey string
+	Profile     string
+	Steps       []string
+	CallbackURL *string
+	OwnerID     string
+	Priority    int
+}
+
+func InsertMedia(ctx context.Context, pool *pgxpool.Pool, m MediaInput) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO media (id, status, original_key, profile, steps, callback_url, owner_id, priority) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		m.ID, m.Status, m.OriginalKey, m.Profile, m.Steps, m.CallbackURL, m.OwnerID, m.Priority,
+	); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, m.ID, HistoryMediaCreated, m.historyPayload()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (m *MediaInput) historyPayload() map[string]any {
+	return map[string]any{"status": m.Status, "profile": m.Profile, "steps": m.Steps, "priority": m.Priority}
+}
+
+// MarkMediaUploaded records the size of the uploaded original and moves the
+// media from INIT or UPLOADED to PROCESSING. It returns a *TransitionError
+// from any other status.
+func MarkMediaUploaded(ctx context.Context, pool *pgxpool.Pool, id string, size int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx,
+		"UPDATE media SET status = 'PROCESSING', size_bytes = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL AND status IN "+mediaUploadFrom,
+		id, size,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return mediaWriteError(ctx, tx, id, "PROCESSING", 0)
+	}
+	if err := recordHistory(ctx, tx, id, HistoryMediaUploaded, map[string]any{"size_bytes": size}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	_ = notifyMedia(ctx, pool, id, "PROCESSING", 0)
+	return nil
 }
 
-func UpdateMediaStatus(ctx context.Context, pool *pgxpool.Pool, id string, status string) error {
-	_, err := pool.Exec(ctx,
-		"UPDATE media SET status = $2, updated_at = NOW() WHERE id = $1",
-		id, status,
+// MarkMediaReady finishes a generation. It returns ErrStaleGeneration if the
+// media has since moved on to a newer generation and a *TransitionError if
+// it was cancelled or deleted.
+func MarkMediaReady(ctx context.Context, pool *pgxpool.Pool, id string, generation int, finalKey string) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		return finishGeneration(ctx, tx, id, generation, "READY", HistoryMediaReady,
+			"UPDATE media SET status = 'READY', final_key = $3, updated_at = NOW() WHERE id = $1 AND generation = $2 AND deleted_at IS NULL AND status IN "+mediaReadyFrom,
+			id, generation, finalKey,
+		)
+	})
+}
+
+// MarkMediaFailed fails a generation, with the same errors as MarkMediaReady.
+func MarkMediaFailed(ctx context.Context, pool *pgxpool.Pool, id string, generation int) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		return failGeneration(ctx, tx, id, generation)
+	})
+}
+
+func failGeneration(ctx context.Context, tx pgx.Tx, id string, generation int) error {
+	return finishGeneration(ctx, tx, id, generation, "FAILED", HistoryMediaFailed,
+		"UPDATE media SET status = 'FAILED', updated_at = NOW() WHERE id = $1 AND generation = $2 AND deleted_at IS NULL AND status IN "+mediaFailFrom,
+		id, generation,
 	)
-	return err
+}
+
+// finishGeneration applies a terminal media update within tx and, if it
+// changed the row, enqueues the webhook for event, records it in the media
+// history and notifies listeners on commit.
+func finishGeneration(ctx context.Context, tx pgx.Tx, id string, generation int, status string, event string, query string, args ...any) error {
+	cmd, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return mediaWriteError(ctx, tx, id, status, generation)
+	}
+	if err := enqueueWebhook(ctx, tx, id, event); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, id, event, map[string]any{"generation": generation}); err != nil {
+		return err
+	}
+	return notifyMedia(ctx, tx, id, status, generation)
 }
 
 type MediaRow struct {
@@ -27,16 +125,439 @@ type MediaRow struct {
 	Status      string
 	OriginalKey string
 	FinalKey    *string
+	Profile     string
+	OwnerID     *string
+	Generation  int
+	Steps       []string
+	Priority    int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const mediaColumns = "id, status, original_key, final_key, profile, owner_id, generation, steps, priority, created_at, updated_at"
+
+// TenantID returns the tenant that owns the media, or "" for media created
+// before API keys existed.
+func (m *MediaRow) TenantID() string {
+	if m.OwnerID == nil {
+		return ""
+	}
+	return *m.OwnerID
+}
+
+func scanMedia(row pgx.Row) (*MediaRow, error) {
+	m := &MediaRow{}
+	if err := row.Scan(&m.ID, &m.Status, &m.OriginalKey, &m.FinalKey, &m.Profile, &m.OwnerID, &m.Generation, &m.Steps, &m.Priority, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
 func GetMedia(ctx context.Context, pool *pgxpool.Pool, id string) (*MediaRow, error) {
 	row := pool.QueryRow(ctx,
-		"SELECT id, status, original_key, final_key FROM media WHERE id = $1",
+		"SELECT "+mediaColumns+" FROM media WHERE id = $1 AND deleted_at IS NULL",
 		id,
 	)
-	m := &MediaRow{}
-	if err := row.Scan(&m.ID, &m.Status, &m.OriginalKey, &m.FinalKey); err != nil {
+	return scanMedia(row)
+}
+
+// MediaFilter narrows ListMedia. Zero values are ignored. Cursor is the ID of
+// the last item of the previous page; results are ordered newest first.
+type MediaFilter struct {
+	Statuses      []string
+	Profile       string
+	OwnerID       string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Cursor        string
+	Limit         int
+}
+
+func ListMedia(ctx context.Context, pool *pgxpool.Pool, f MediaFilter) ([]*MediaRow, error) {
+	var (
+		where = []string{"deleted_at IS NULL"}
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if len(f.Statuses) > 0 {
+		add("status = ANY(?::media_status[])", f.Statuses)
+	}
+	if f.Profile != "" {
+		add("profile = ?", f.Profile)
+	}
+	if f.OwnerID != "" {
+		add("owner_id = ?", f.OwnerID)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < ?", f.CreatedBefore)
+	}
+	if f.Cursor != "" {
+		add("id < ?", f.Cursor)
+	}
+
+	query := "SELECT " + mediaColumns + " FROM media WHERE " + strings.Join(where, " AND ")
+	args = append(args, f.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
 		return nil, err
 	}
-	return m, nil
+	defer rows.Close()
+
+	var items []*MediaRow
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	return items, rows.Err()
+}
+
+// ExpireStaleMedia moves up to limit INIT media older than ttl to EXPIRED and
+// returns their IDs. The processing run charged when each was created is
+// refunded to its owner's usage for that month, since it never ran.
+func ExpireStaleMedia(ctx context.Context, pool *pgxpool.Pool, ttl time.Duration, limit int) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`WITH e AS (
+			UPDATE media SET status = 'EXPIRED', updated_at = NOW() WHERE id IN (SELECT id FROM media WHERE status IN `+mediaExpireFrom+` AND created_at < NOW() - ($1 * INTERVAL '1 second') ORDER BY created_at LIMIT $2 FOR UPDATE SKIP LOCKED) RETURNING id, owner_id, created_at
+		), refund AS (
+			UPDATE tenant_usage u SET processing_runs = GREATEST(u.processing_runs - r.runs, 0)
+			FROM (
+				SELECT owner_id, date_trunc('month', created_at AT TIME ZONE 'UTC')::date AS month, COUNT(*) AS runs
+				FROM e WHERE owner_id IS NOT NULL GROUP BY 1, 2
+			) r
+			WHERE u.tenant_id = r.owner_id AND u.month = r.month
+		), h AS (
+			INSERT INTO media_event (media_id, kind, actor) SELECT id, $3::text, $4::text FROM e
+		)
+		SELECT id FROM e`,
+		int(ttl.Seconds()), limit, HistoryMediaExpired, actorFrom(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		_ = notifyMedia(ctx, pool, id, "EXPIRED", 0)
+	}
+	return ids, nil
+}
+
+// MediaRef identifies media together with the tenant its objects are stored
+// under.
+type MediaRef struct {
+	ID       string
+	TenantID string
+}
+
+// ListUnpurgedMedia returns expired or deleted media whose objects have not
+// been removed yet.
+func ListUnpurgedMedia(ctx context.Context, pool *pgxpool.Pool, limit int) ([]MediaRef, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT id, COALESCE(owner_id, '') FROM media WHERE (status = 'EXPIRED' OR deleted_at IS NOT NULL) AND objects_purged_at IS NULL ORDER BY updated_at LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRefs(rows)
+}
+
+// SetMediaSize records the size of the media's original.
+func SetMediaSize(ctx context.Context, pool *pgxpool.Pool, id string, size int64) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE media SET size_bytes = $2, updated_at = NOW() WHERE id = $1",
+		id, size,
+	)
+	return err
+}
+
+func MarkMediaPurged(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE media SET objects_purged_at = NOW(), updated_at = NOW() WHERE id = $1",
+		id,
+	)
+	return err
+}
+
+func scanIDs(rows pgx.Rows) ([]string, error) {
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func scanRefs(rows pgx.Rows) ([]MediaRef, error) {
+	defer rows.Close()
+	var refs []MediaRef
+	for rows.Next() {
+		var r MediaRef
+		if err := rows.Scan(&r.ID, &r.TenantID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, rows.Err()
+}
+
+// SoftDeleteMedia marks media as deleted, schedules the row for hard deletion
+// after grace and cancels its outstanding tasks. It returns false if the media
+// does not exist or was already deleted.
+func SoftDeleteMedia(ctx context.Context, pool *pgxpool.Pool, id string, grace time.Duration) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx,
+		"UPDATE media SET deleted_at = NOW(), purge_after = NOW() + ($2 * INTERVAL '1 second'), updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL",
+		id, int(grace.Seconds()),
+	)
+	if err != nil {
+		return false, err
+	}
+	if cmd.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := cancelOutstandingTasks(ctx, tx, id); err != nil {
+		return false, err
+	}
+	if err := recordHistory(ctx, tx, id, HistoryMediaDeleted, map[string]any{"purge_after_seconds": int(grace.Seconds())}); err != nil {
+		return false, err
+	}
+	if err := notifyMedia(ctx, tx, id, "DELETED", 0); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// CancelMedia stops processing of media that has not finished yet: the media
+// moves to CANCELLED and its outstanding tasks are cancelled. Workers running
+// one of those tasks notice on their next lease heartbeat. It returns
+// pgx.ErrNoRows if the media does not exist and a *TransitionError if it is
+// already in a terminal state.
+func CancelMedia(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx,
+		"UPDATE media SET status = 'CANCELLED', updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL AND status IN "+mediaCancelFrom,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return mediaWriteError(ctx, tx, id, "CANCELLED", 0)
+	}
+
+	if err := cancelOutstandingTasks(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, id, HistoryMediaCancelled, nil); err != nil {
+		return err
+	}
+	if err := notifyMedia(ctx, tx, id, "CANCELLED", 0); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func cancelOutstandingTasks(ctx context.Context, tx pgx.Tx, mediaID string) error {
+	_, err := tx.Exec(ctx,
+		"UPDATE processing_task SET status = 'CANCELLED', lock_by = NULL, lock_until = NULL, updated_at = NOW() WHERE media_id = $1 AND status IN "+taskCancelFrom,
+		mediaID,
+	)
+	return err
+}
+
+// ListPurgeableMedia returns deleted media whose grace period has elapsed and
+// whose objects have already been removed.
+func ListPurgeableMedia(ctx context.Context, pool *pgxpool.Pool, limit int) ([]MediaRef, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT id, COALESCE(owner_id, '') FROM media WHERE deleted_at IS NOT NULL AND purge_after < NOW() AND objects_purged_at IS NOT NULL ORDER BY purge_after LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRefs(rows)
+}
+
+// HardDeleteMedia removes a deleted media row; its tasks go with it via ON
+// DELETE CASCADE, while its history is kept.
+func HardDeleteMedia(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx,
+		"DELETE FROM media WHERE id = $1 AND deleted_at IS NOT NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return nil
+	}
+	if err := recordHistory(ctx, tx, id, HistoryMediaPurged, nil); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GenerationInput describes a reprocess run. FromGeneration guards against two
+// concurrent reprocess requests both starting the next generation.
+type GenerationInput struct {
+	MediaID        string
+	FromGeneration int
+	Profile        string
+	Steps          []string
+	Priority       int
+	FirstTask      ProcessingTaskInput
+}
+
+// StartGeneration bumps the media to the next generation and inserts the
+// first task of that generation. It returns a *TransitionError if the media
+// is not in a terminal state and ErrStaleGeneration if another generation
+// was started first.
+func StartGeneration(ctx context.Context, pool *pgxpool.Pool, g GenerationInput) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx,
+		"UPDATE media SET generation = generation + 1, profile = $3, steps = $4, priority = $5, status = 'PROCESSING', updated_at = NOW() WHERE id = $1 AND generation = $2 AND deleted_at IS NULL AND status IN "+mediaReprocessFrom,
+		g.MediaID, g.FromGeneration, g.Profile, g.Steps, g.Priority,
+	)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return mediaWriteError(ctx, tx, g.MediaID, "PROCESSING", g.FromGeneration)
+	}
+
+	if err := recordHistory(ctx, tx, g.MediaID, HistoryMediaReprocessed, map[string]any{
+		"generation": g.FromGeneration + 1, "profile": g.Profile, "steps": g.Steps, "priority": g.Priority,
+	}); err != nil {
+		return err
+	}
+	if err := notifyMedia(ctx, tx, g.MediaID, "PROCESSING", g.FromGeneration+1); err != nil {
+		return err
+	}
+
+	t := g.FirstTask
+	if _, err := execTaskEvent(ctx, tx, HistoryTaskQueued,
+		"INSERT INTO processing_task (id, media_id, generation, step, status, input_key, output_key, priority, correlation_id, trace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		t.ID, t.MediaID, t.Generation, t.Step, t.Status, t.InputKey, t.OutputKey, t.Priority, t.CorrelationID, t.TraceID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// InsertMediaWithTask inserts media that skips the client upload together with
+// the first task of its pipeline.
+func InsertMediaWithTask(ctx context.Context, pool *pgxpool.Pool, m MediaInput, t ProcessingTaskInput) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO media (id, status, original_key, profile, steps, callback_url, owner_id, priority) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		m.ID, m.Status, m.OriginalKey, m.Profile, m.Steps, m.CallbackURL, m.OwnerID, m.Priority,
+	); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, m.ID, HistoryMediaCreated, m.historyPayload()); err != nil {
+		return err
+	}
+	if _, err := execTaskEvent(ctx, tx, HistoryTaskQueued,
+		"INSERT INTO processing_task (id, media_id, generation, step, status, input_key, output_key, priority, correlation_id, trace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		t.ID, t.MediaID, t.Generation, t.Step, t.Status, t.InputKey, t.OutputKey, t.Priority, t.CorrelationID, t.TraceID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// InsertMediaBatch inserts all media in a single transaction.
+func InsertMediaBatch(ctx context.Context, pool *pgxpool.Pool, items []MediaInput) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, m := range items {
+		batch.Queue(
+			"INSERT INTO media (id, status, original_key, profile, steps, callback_url, owner_id, priority) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			m.ID, m.Status, m.OriginalKey, m.Profile, m.Steps, m.CallbackURL, m.OwnerID, m.Priority,
+		)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	for _, m := range items {
+		if err := recordHistory(ctx, tx, m.ID, HistoryMediaCreated, m.historyPayload()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetMediaBatch returns the non-deleted media among ids, keyed by ID.
+func GetMediaBatch(ctx context.Context, pool *pgxpool.Pool, ids []string) (map[string]*MediaRow, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT "+mediaColumns+" FROM media WHERE id = ANY($1) AND deleted_at IS NULL",
+		ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]*MediaRow, len(ids))
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		found[m.ID] = m
+	}
+	return found, rows.Err()
 }
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// schedulerLockKey is the advisory lock that keeps one scheduler dispatching
+// at a time when several workers run one.
+const schedulerLockKey = 0x5c4ed
+
+// TenantLoad is a tenant's share of the task backlog as seen by the
+// scheduler. Tasks of media without an owner belong to tenant "".
+type TenantLoad struct {
+	TenantID    string
+	Weight      int
+	MaxInFlight *int
+	// InFlight counts dispatched tasks, queued or running, across all steps.
+	InFlight int
+	// Ready counts held tasks that may be dispatched now, by step.
+	Ready map[string]int
+}
+
+// DispatchPlan is the number of tasks to dispatch per step and tenant.
+type DispatchPlan map[string]map[string]int
+
+// DispatchTasks publishes held tasks chosen by plan. plan receives the
+// number of dispatched tasks still waiting in each step queue and the load
+// of every tenant with outstanding work. Within a tenant, higher priority
+// and then older tasks go first. Tasks are marked dispatched only after
+// publishing; on a publish error the tasks published so far are kept and
+// the error returned. When another scheduler holds the lock, nothing is
+// dispatched.
+func DispatchTasks(ctx context.Context, pool *pgxpool.Pool,
+	plan func(queued map[string]int, loads []*TenantLoad) DispatchPlan,
+	publish func(tenantID string, t *ProcessingTaskRow) error,
+) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", schedulerLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	queued := map[string]int{}
+	rows, err := tx.Query(ctx,
+		"SELECT step::text, COUNT(*) FROM processing_task WHERE NOT deferred AND status IN ('PENDING','RETRY') GROUP BY step",
+	)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var step string
+		var n int
+		if err := rows.Scan(&step, &n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		queued[step] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rows, err = tx.Query(ctx,
+		`SELECT COALESCE(m.owner_id, ''), t.step::text,
+			COUNT(*) FILTER (WHERE t.deferred AND t.status IN ('PENDING','RETRY') AND (t.lock_until IS NULL OR t.lock_until < NOW())),
+			COUNT(*) FILTER (WHERE NOT t.deferred),
+			COALESCE(MAX(tn.weight), 1), MAX(tn.max_in_flight)
+		FROM processing_task t
+		JOIN media m ON m.id = t.media_id
+		LEFT JOIN tenant tn ON tn.id = m.owner_id
+		WHERE t.status IN ('PENDING','RETRY','RUNNING')
+		GROUP BY 1, 2`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	byTenant := map[string]*TenantLoad{}
+	var loads []*TenantLoad
+	for rows.Next() {
+		var tenantID, step string
+		var ready, inFlight, weight int
+		var maxInFlight *int
+		if err := rows.Scan(&tenantID, &step, &ready, &inFlight, &weight, &maxInFlight); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		l, ok := byTenant[tenantID]
+		if !ok {
+			l = &TenantLoad{TenantID: tenantID, Weight: weight, MaxInFlight: maxInFlight, Ready: map[string]int{}}
+			byTenant[tenantID] = l
+			loads = append(loads, l)
+		}
+		l.InFlight += inFlight
+		l.Ready[step] += ready
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var dispatched []string
+	var pubErr error
+dispatch:
+	for step, tenants := range plan(queued, loads) {
+		for tenantID, n := range tenants {
+			tasks, err := selectReadyTasks(ctx, tx, step, tenantID, n)
+			if err != nil {
+				return 0, err
+			}
+			for _, t := range tasks {
+				if pubErr = publish(tenantID, t); pubErr != nil {
+					break dispatch
+				}
+				dispatched = append(dispatched, t.ID)
+			}
+		}
+	}
+
+	if len(dispatched) > 0 {
+		if _, err := tx.Exec(ctx,
+			"UPDATE processing_task SET deferred = FALSE, enqueued_at = NOW() WHERE id = ANY($1)",
+			dispatched,
+		); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(dispatched), pubErr
+}
+
+// RedispatchStaleTasks hands up to limit dispatched tasks that have waited
+// longer than timeout without being claimed back to the scheduler. Their
+// message was lost, e.g. dropped by a broker restart or dead-lettered, and
+// they would otherwise hold a queue slot and their tenant's in-flight count
+// forever. Should the old message turn up after all, the claim it triggers
+// makes the new one a no-op.
+func RedispatchStaleTasks(ctx context.Context, pool *pgxpool.Pool, limit int, timeout time.Duration) (int, error) {
+	cmd, err := pool.Exec(ctx,
+		`UPDATE processing_task SET deferred = TRUE, enqueued_at = NULL
+		WHERE id IN (
+			SELECT id FROM processing_task
+			WHERE NOT deferred AND status IN ('PENDING','RETRY') AND COALESCE(enqueued_at, updated_at) < NOW() - ($2 * INTERVAL '1 second')
+			LIMIT $1 FOR UPDATE SKIP LOCKED
+		)`,
+		limit, int(timeout.Seconds()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(cmd.RowsAffected()), nil
+}
+
+func selectReadyTasks(ctx context.Context, tx pgx.Tx, step, tenantID string, limit int) ([]*ProcessingTaskRow, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT `+taskColumns+` FROM processing_task
+		WHERE deferred AND status IN ('PENDING','RETRY') AND (lock_until IS NULL OR lock_until < NOW()) AND step = $1
+			AND media_id IN (SELECT id FROM media WHERE COALESCE(owner_id, '') = $2)
+		ORDER BY priority DESC, created_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED`,
+		step, tenantID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*ProcessingTaskRow
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// ReadyAt is when a held task became eligible for dispatch: its creation or,
+// for a retry, the end of its backoff or the moment its lease expired.
+func (t *ProcessingTaskRow) ReadyAt() time.Time {
+	if t.Status != "RETRY" {
+		return t.CreatedAt
+	}
+	if t.LockUntil != nil {
+		return *t.LockUntil
+	}
+	return t.UpdatedAt
+}
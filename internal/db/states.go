@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// mediaTransitions and taskTransitions list, for each status, the statuses
+// it may move to. Every status write in this package is a conditional UPDATE
+// that only matches rows in an allowed from-state, so a late or concurrent
+// writer cannot move a row backwards.
+var mediaTransitions = map[string][]string{
+	"INIT":       {"PROCESSING", "EXPIRED", "CANCELLED"},
+	"UPLOADED":   {"PROCESSING", "CANCELLED"},
+	"PROCESSING": {"READY", "FAILED", "CANCELLED"},
+	"READY":      {"PROCESSING"},
+	"FAILED":     {"PROCESSING"},
+	"CANCELLED":  {"PROCESSING"},
+	"EXPIRED":    nil,
+}
+
+var taskTransitions = map[string][]string{
+	"PENDING":   {"RUNNING", "CANCELLED"},
+	"RETRY":     {"RUNNING", "CANCELLED"},
+	"RUNNING":   {"SUCCEEDED", "FAILED", "RETRY", "CANCELLED"},
+	"SUCCEEDED": nil,
+	"FAILED":    nil,
+	"CANCELLED": nil,
+}
+
+// SQL lists of the from-states each write accepts. Explicit lists are
+// recorded in stateWrites and checked against the tables by the tests.
+var (
+	mediaUploadFrom    = fromStates(mediaTransitions, "PROCESSING", "INIT", "UPLOADED")
+	mediaReprocessFrom = fromStates(mediaTransitions, "PROCESSING", "READY", "FAILED", "CANCELLED")
+	mediaReadyFrom     = fromStates(mediaTransitions, "READY")
+	mediaFailFrom      = fromStates(mediaTransitions, "FAILED")
+	mediaCancelFrom    = fromStates(mediaTransitions, "CANCELLED")
+	mediaExpireFrom    = fromStates(mediaTransitions, "EXPIRED")
+
+	taskClaimFrom   = fromStates(taskTransitions, "RUNNING")
+	taskSucceedFrom = fromStates(taskTransitions, "SUCCEEDED")
+	taskFailFrom    = fromStates(taskTransitions, "FAILED")
+	taskRetryFrom   = fromStates(taskTransitions, "RETRY")
+	taskCancelFrom  = fromStates(taskTransitions, "CANCELLED")
+)
+
+var (
+	// ErrIllegalTransition matches every *TransitionError.
+	ErrIllegalTransition = errors.New("illegal state transition")
+	// ErrLeaseLost is returned for worker writes to a task leased to another
+	// worker or under a later epoch, or no longer leased at all.
+	ErrLeaseLost = errors.New("task lease not held by this worker")
+	// ErrStaleGeneration is returned for writes to a generation the media
+	// has moved on from.
+	ErrStaleGeneration = errors.New("media generation is stale")
+)
+
+// TransitionError reports a status write the state machine does not allow
+// from the row's current status. From is "DELETED" for soft-deleted media.
+type TransitionError struct {
+	Entity string
+	ID     string
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s %s: cannot move from %s to %s", e.Entity, e.ID, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// CanTransitionMedia reports whether media may move from one status to another.
+func CanTransitionMedia(from, to string) bool {
+	return slices.Contains(mediaTransitions[from], to)
+}
+
+// CanTransitionTask reports whether a task may move from one status to another.
+func CanTransitionTask(from, to string) bool {
+	return slices.Contains(taskTransitions[from], to)
+}
+
+// stateWrite is a status write declared with fromStates.
+type stateWrite struct {
+	table map[string][]string
+	to    string
+	from  []string
+}
+
+// stateWrites records every fromStates call so the tests can check explicit
+// from-states against the transition tables.
+var stateWrites []stateWrite
+
+// fromStates returns the SQL list "('A','B')" of from-states for a write to
+// status to. Without explicit from-states it lists every status that may
+// move to it.
+func fromStates(table map[string][]string, to string, from ...string) string {
+	if len(from) == 0 {
+		for state, next := range table {
+			if slices.Contains(next, to) {
+				from = append(from, state)
+			}
+		}
+		slices.Sort(from)
+	}
+	stateWrites = append(stateWrites, stateWrite{table: table, to: to, from: from})
+	quoted := make([]string, len(from))
+	for i, state := range from {
+		quoted[i] = "'" + state + "'"
+	}
+	return "(" + strings.Join(quoted, ",") + ")"
+}
+
+// mediaWriteError explains why a conditional media write to status to
+// matched no row. A generation of 0 skips the generation check. It returns
+// pgx.ErrNoRows if the media does not exist.
+func mediaWriteError(ctx context.Context, q querier, id string, to string, generation int) error {
+	var (
+		from    string
+		current int
+		deleted bool
+	)
+	if err := q.QueryRow(ctx,
+		"SELECT status, generation, deleted_at IS NOT NULL FROM media WHERE id = $1",
+		id,
+	).Scan(&from, &current, &deleted); err != nil {
+		return err
+	}
+	if deleted {
+		from = "DELETED"
+	} else if generation != 0 && current != generation {
+		return fmt.Errorf("media %s generation %d (current %d): %w", id, generation, current, ErrStaleGeneration)
+	}
+	return &TransitionError{Entity: "media", ID: id, From: from, To: to}
+}
+
+// taskWriteError explains why a fenced write of a worker's task to status to
+// matched no row. It returns pgx.ErrNoRows if the task does not exist.
+func taskWriteError(ctx context.Context, q querier, id string, to string, workerID string, epoch int64) error {
+	var (
+		from    string
+		lockBy  *string
+		current int64
+	)
+	if err := q.QueryRow(ctx,
+		"SELECT status, lock_by, lease_epoch FROM processing_task WHERE id = $1",
+		id,
+	).Scan(&from, &lockBy, &current); err != nil {
+		return err
+	}
+	if current != epoch {
+		return fmt.Errorf("task %s epoch %d (current %d): %w", id, epoch, current, ErrLeaseLost)
+	}
+	if CanTransitionTask(from, to) && (lockBy == nil || *lockBy != workerID) {
+		return fmt.Errorf("task %s: %w", id, ErrLeaseLost)
+	}
+	return &TransitionError{Entity: "task", ID: id, From: from, To: to}
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestTransitionTablesAreClosed(t *testing.T) {
+	for name, table := range map[string]map[string][]string{"media": mediaTransitions, "task": taskTransitions} {
+		for from, next := range table {
+			for _, to := range next {
+				if _, ok := table[to]; !ok {
+					t.Errorf("%s: %s -> %s leads to an unknown status", name, from, to)
+				}
+			}
+		}
+	}
+}
+
+func TestStateWritesAreAllowed(t *testing.T) {
+	if len(stateWrites) == 0 {
+		t.Fatal("no state writes recorded")
+	}
+	for _, w := range stateWrites {
+		if _, ok := w.table[w.to]; !ok {
+			t.Errorf("write to unknown status %s", w.to)
+		}
+		if len(w.from) == 0 {
+			t.Errorf("write to %s accepts no from-state", w.to)
+		}
+		for _, from := range w.from {
+			if !slices.Contains(w.table[from], w.to) {
+				t.Errorf("write to %s accepts %s, which may not move to it", w.to, from)
+			}
+		}
+	}
+}
+
+func TestFromStates(t *testing.T) {
+	n := len(stateWrites)
+	defer func() { stateWrites = stateWrites[:n] }()
+
+	if got := fromStates(taskTransitions, "RUNNING"); got != "('PENDING','RETRY')" {
+		t.Errorf("derived from-states = %s", got)
+	}
+	if got := fromStates(mediaTransitions, "PROCESSING", "READY", "FAILED"); got != "('READY','FAILED')" {
+		t.Errorf("explicit from-states = %s", got)
+	}
+}
+
+// fakeQuerier answers QueryRow with one row of values, or err.
+type fakeQuerier struct {
+	values []any
+	err    error
+}
+
+func (q fakeQuerier) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("unexpected Exec")
+}
+
+func (q fakeQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow(q)
+}
+
+type fakeRow fakeQuerier
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(dest) != len(r.values) {
+		return fmt.Errorf("scan into %d values, have %d", len(dest), len(r.values))
+	}
+	for i, v := range r.values {
+		switch d := dest[i].(type) {
+		case *string:
+			*d = v.(string)
+		case **string:
+			*d, _ = v.(*string)
+		case *int:
+			*d = v.(int)
+		case *int64:
+			*d = v.(int64)
+		case *bool:
+			*d = v.(bool)
+		default:
+			return fmt.Errorf("unsupported scan type %T", d)
+		}
+	}
+	return nil
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestTaskWriteError(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		q         fakeQuerier
+		to        string
+		leaseLost bool
+		from      string
+	}{
+		{
+			name:      "re-claimed under a later epoch",
+			q:         fakeQuerier{values: []any{"RUNNING", strPtr("w2"), int64(3)}},
+			to:        "SUCCEEDED",
+			leaseLost: true,
+		},
+		{
+			name:      "epoch moved on and task since cancelled",
+			q:         fakeQuerier{values: []any{"CANCELLED", (*string)(nil), int64(3)}},
+			to:        "SUCCEEDED",
+			leaseLost: true,
+		},
+		{
+			name:      "running under another worker",
+			q:         fakeQuerier{values: []any{"RUNNING", strPtr("w2"), int64(2)}},
+			to:        "RETRY",
+			leaseLost: true,
+		},
+		{
+			name:      "lease released by the sweeper",
+			q:         fakeQuerier{values: []any{"RETRY", (*string)(nil), int64(2)}},
+			to:        "FAILED",
+			leaseLost: false,
+			from:      "RETRY",
+		},
+		{
+			name:      "running with no lease holder",
+			q:         fakeQuerier{values: []any{"RUNNING", (*string)(nil), int64(2)}},
+			to:        "FAILED",
+			leaseLost: true,
+		},
+		{
+			name: "cancelled under the same lease",
+			q:    fakeQuerier{values: []any{"CANCELLED", strPtr("w1"), int64(2)}},
+			to:   "SUCCEEDED",
+			from: "CANCELLED",
+		},
+		{
+			name: "already succeeded",
+			q:    fakeQuerier{values: []any{"SUCCEEDED", (*string)(nil), int64(2)}},
+			to:   "RETRY",
+			from: "SUCCEEDED",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := taskWriteError(context.Background(), tc.q, "t1", tc.to, "w1", 2)
+			if errors.Is(err, ErrLeaseLost) != tc.leaseLost {
+				t.Fatalf("err = %v, lease lost = %v, want %v", err, !tc.leaseLost, tc.leaseLost)
+			}
+			if tc.leaseLost {
+				if errors.Is(err, ErrIllegalTransition) {
+					t.Errorf("err = %v is also an illegal transition", err)
+				}
+				return
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || !errors.Is(err, ErrIllegalTransition) {
+				t.Fatalf("err = %v, want a *TransitionError", err)
+			}
+			if te.Entity != "task" || te.From != tc.from || te.To != tc.to {
+				t.Errorf("err = %+v, want task %s -> %s", te, tc.from, tc.to)
+			}
+		})
+	}
+}
+
+func TestTaskWriteErrorMissingTask(t *testing.T) {
+	err := taskWriteError(context.Background(), fakeQuerier{err: pgx.ErrNoRows}, "t1", "SUCCEEDED", "w1", 1)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("err = %v, want pgx.ErrNoRows", err)
+	}
+}
+
+func TestMediaWriteError(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		values     []any
+		generation int
+		stale      bool
+		from       string
+	}{
+		{"illegal transition", []any{"READY", 2, false}, 2, false, "READY"},
+		{"generation check skipped", []any{"READY", 2, false}, 0, false, "READY"},
+		{"stale generation", []any{"PROCESSING", 3, false}, 2, true, ""},
+		{"soft-deleted", []any{"PROCESSING", 3, true}, 2, false, "DELETED"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mediaWriteError(context.Background(), fakeQuerier{values: tc.values}, "m1", "READY", tc.generation)
+			if errors.Is(err, ErrStaleGeneration) != tc.stale {
+				t.Fatalf("err = %v, stale = %v, want %v", err, !tc.stale, tc.stale)
+			}
+			if tc.stale {
+				return
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.Entity != "media" || te.From != tc.from {
+				t.Errorf("err = %v, want a media transition from %s", err, tc.from)
+			}
+		})
+	}
+}
@@ -2,88 +2,244 @@ package db
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type ProcessingTaskInput struct {
-	ID        string
-	MediaID   string
-	Step      string
-	Status    string
-	InputKey  string
-	OutputKey string
+	ID         string
+	MediaID    string
+	Generation int
+	Step       string
+	Status     string
+	InputKey   string
+	OutputKey  string
+	Priority   int
+	// CorrelationID and TraceID identify the request that started the run.
+	CorrelationID *string
+	TraceID       *string
 }
 
 type ProcessingTaskRow struct {
 	ID         string
 	MediaID    string
+	Generation int
 	Step       string
 	Status     string
+	Priority   int
 	RetryCount int
+	LockBy     *string
+	LockUntil  *time.Time
+	// LeaseEpoch is the fencing token of the latest claim.
+	LeaseEpoch int64
 	InputKey   string
 	OutputKey  string
+	LastError  *string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	CorrelationID *string
+	TraceID       *string
+}
+
+const taskColumns = "id, media_id, generation, step, status, priority, retry_count, lock_by, lock_until, lease_epoch, input_key, output_key, last_error, created_at, updated_at, correlation_id, trace_id"
+
+func scanTask(row pgx.Row) (*ProcessingTaskRow, error) {
+	var t ProcessingTaskRow
+	if err := row.Scan(&t.ID, &t.MediaID, &t.Generation, &t.Step, &t.Status, &t.Priority, &t.RetryCount, &t.LockBy, &t.LockUntil, &t.LeaseEpoch, &t.InputKey, &t.OutputKey, &t.LastError, &t.CreatedAt, &t.UpdatedAt, &t.CorrelationID, &t.TraceID); err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
 func InsertProcessingTask(ctx context.Context, pool *pgxpool.Pool, t ProcessingTaskInput) (bool, error) {
-	cmd, err := pool.Exec(ctx,
-		"INSERT INTO processing_task (id, media_id, step, status, input_key, output_key) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (media_id, step) DO NOTHING",
-		t.ID, t.MediaID, t.Step, t.Status, t.InputKey, t.OutputKey,
+	return execTaskEvent(ctx, pool, HistoryTaskQueued,
+		"INSERT INTO processing_task (id, media_id, generation, step, status, input_key, output_key, priority, correlation_id, trace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (media_id, generation, step) DO NOTHING",
+		t.ID, t.MediaID, t.Generation, t.Step, t.Status, t.InputKey, t.OutputKey, t.Priority, t.CorrelationID, t.TraceID,
 	)
-	if err != nil {
-		return false, err
-	}
-	return cmd.RowsAffected() == 1, nil
 }
 
 func GetTask(ctx context.Context, pool *pgxpool.Pool, taskID string) (*ProcessingTaskRow, error) {
 	row := pool.QueryRow(ctx,
-		"SELECT id, media_id, step, status, retry_count, input_key, output_key FROM processing_task WHERE id = $1",
+		"SELECT "+taskColumns+" FROM processing_task WHERE id = $1",
 		taskID,
 	)
-	var t ProcessingTaskRow
-	if err := row.Scan(&t.ID, &t.MediaID, &t.Step, &t.Status, &t.RetryCount, &t.InputKey, &t.OutputKey); err != nil {
+	return scanTask(row)
+}
+
+// ListTasksByMedia returns every task of a media across all generations,
+// oldest first.
+func ListTasksByMedia(ctx context.Context, pool *pgxpool.Pool, mediaID string) ([]*ProcessingTaskRow, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT "+taskColumns+" FROM processing_task WHERE media_id = $1 ORDER BY generation, created_at",
+		mediaID,
+	)
+	if err != nil {
 		return nil, err
 	}
-	return &t, nil
+	defer rows.Close()
+
+	var tasks []*ProcessingTaskRow
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
 }
 
-func ClaimTask(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, leaseSeconds int) (bool, error) {
-	cmd, err := pool.Exec(ctx,
-		"UPDATE processing_task SET status = 'RUNNING', lock_by = $2, lock_until = NOW() + ($3 * INTERVAL '1 second'), updated_at = NOW() WHERE id = $1 AND status IN ('PENDING','RETRY') AND (lock_until IS NULL OR lock_until < NOW())",
+// ClaimTask leases a PENDING or RETRY task to workerID and returns the new
+// lease epoch, which every later write under the lease must present.
+// CANCELLED, finished and currently leased tasks are never claimed.
+func ClaimTask(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, leaseSeconds int) (int64, bool, error) {
+	return queryTaskEvent(ctx, pool, HistoryTaskStarted,
+		"UPDATE processing_task SET status = 'RUNNING', lock_by = $2, lock_until = NOW() + ($3 * INTERVAL '1 second'), lease_epoch = lease_epoch + 1, deferred = FALSE, updated_at = NOW() WHERE id = $1 AND status IN "+taskClaimFrom+" AND (lock_until IS NULL OR lock_until < NOW())",
 		taskID, workerID, leaseSeconds,
 	)
+}
+
+// ExtendLease pushes out the lease of a task the worker still holds. It
+// returns false once the task is no longer RUNNING under workerID and epoch,
+// which is how a worker learns that its task was cancelled or re-claimed.
+func ExtendLease(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, epoch int64, leaseSeconds int) (bool, error) {
+	cmd, err := pool.Exec(ctx,
+		"UPDATE processing_task SET lock_until = NOW() + ($4 * INTERVAL '1 second'), updated_at = NOW() WHERE id = $1 AND status = 'RUNNING' AND lock_by = $2 AND lease_epoch = $3",
+		taskID, workerID, epoch, leaseSeconds,
+	)
 	if err != nil {
 		return false, err
 	}
 	return cmd.RowsAffected() == 1, nil
 }
 
-func MarkTaskSucceeded(ctx context.Context, pool *pgxpool.Pool, taskID string) error {
-	_, err := pool.Exec(ctx,
-		"UPDATE processing_task SET status = 'SUCCEEDED', lock_by = NULL, lock_until = NULL, updated_at = NOW() WHERE id = $1",
-		taskID,
+// MarkTaskSucceeded completes a task leased to workerID under epoch. It
+// returns ErrLeaseLost if the task has since been re-claimed or the lease has
+// passed to someone else, and a *TransitionError if the task is no longer
+// RUNNING, e.g. cancelled.
+func MarkTaskSucceeded(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, epoch int64) error {
+	return execWorkerTaskEvent(ctx, pool, taskID, "SUCCEEDED", workerID, epoch, HistoryTaskSucceeded,
+		"UPDATE processing_task SET status = 'SUCCEEDED', lock_by = NULL, lock_until = NULL, updated_at = NOW() WHERE id = $1 AND lock_by = $2 AND lease_epoch = $3 AND status IN "+taskSucceedFrom,
+		taskID, workerID, epoch,
 	)
-	return err
 }
 
-func MarkTaskFailed(ctx context.Context, pool *pgxpool.Pool, taskID string, errMsg string) error {
-	_, err := pool.Exec(ctx,
-		"UPDATE processing_task SET status = 'FAILED', last_error = $2, lock_by = NULL, lock_until = NULL, updated_at = NOW() WHERE id = $1",
-		taskID, errMsg,
+// MarkTaskFailed fails a task leased to workerID under epoch for good, with
+// the same errors as MarkTaskSucceeded.
+func MarkTaskFailed(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, epoch int64, errMsg string) error {
+	return execWorkerTaskEvent(ctx, pool, taskID, "FAILED", workerID, epoch, HistoryTaskFailed,
+		"UPDATE processing_task SET status = 'FAILED', last_error = $4, lock_by = NULL, lock_until = NULL, updated_at = NOW() WHERE id = $1 AND lock_by = $2 AND lease_epoch = $3 AND status IN "+taskFailFrom,
+		taskID, workerID, epoch, errMsg,
 	)
-	return err
 }
 
-func MarkTaskRetry(ctx context.Context, pool *pgxpool.Pool, taskID string, errMsg string, backoff time.Duration) error {
+// MarkTaskRetry puts a failed task leased to workerID under epoch back in
+// the scheduler's backlog, eligible for dispatch once backoff has elapsed. It
+// returns the same errors as MarkTaskSucceeded.
+func MarkTaskRetry(ctx context.Context, pool *pgxpool.Pool, taskID string, workerID string, epoch int64, errMsg string, backoff time.Duration) error {
 	seconds := int(backoff.Seconds())
 	if seconds <= 0 {
 		seconds = 30
 	}
-	_, err := pool.Exec(ctx,
-		"UPDATE processing_task SET status = 'RETRY', retry_count = retry_count + 1, last_error = $2, lock_by = NULL, lock_until = NOW() + ($3 * INTERVAL '1 second'), updated_at = NOW() WHERE id = $1",
-		taskID, errMsg, seconds,
+	return execWorkerTaskEvent(ctx, pool, taskID, "RETRY", workerID, epoch, HistoryTaskRetrying,
+		"UPDATE processing_task SET status = 'RETRY', retry_count = retry_count + 1, last_error = $4, lock_by = NULL, lock_until = NOW() + ($5 * INTERVAL '1 second'), deferred = TRUE, updated_at = NOW() WHERE id = $1 AND lock_by = $2 AND lease_epoch = $3 AND status IN "+taskRetryFrom,
+		taskID, workerID, epoch, errMsg, seconds,
 	)
-	return err
+}
+
+// execWorkerTaskEvent runs a worker's fenced task write through
+// execTaskEvent and explains a write that matched no row.
+func execWorkerTaskEvent(ctx context.Context, pool *pgxpool.Pool, taskID string, to string, workerID string, epoch int64, kind string, query string, args ...any) error {
+	ok, err := execTaskEvent(ctx, pool, kind, query, args...)
+	if err != nil || ok {
+		return err
+	}
+	return taskWriteError(ctx, pool, taskID, to, workerID, epoch)
+}
+
+// CountTaskBacklog returns the number of tasks waiting for a worker, whether
+// still held by the scheduler or already published.
+func CountTaskBacklog(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var n int
+	err := pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM processing_task WHERE status IN ('PENDING','RETRY')",
+	).Scan(&n)
+	return n, err
+}
+
+// RequeueExpiredTasks handles up to limit RUNNING tasks whose lease expired
+// because their worker died or hung. Tasks with retries left go back to the
+// scheduler; a task that used up maxRetries, e.g. on an input that crashes
+// every worker, fails along with its media generation. Open attempts are
+// closed and everything is recorded in the media history, in one
+// transaction. The old worker notices on its next heartbeat and aborts.
+func RequeueExpiredTasks(ctx context.Context, pool *pgxpool.Pool, limit int, maxRetries int) (requeued int, failed int, err error) {
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx,
+			`WITH expired AS (
+				UPDATE processing_task SET
+					status = (CASE WHEN retry_count + 1 >= $2 THEN 'FAILED' ELSE 'RETRY' END)::task_status,
+					retry_count = CASE WHEN retry_count + 1 >= $2 THEN retry_count ELSE retry_count + 1 END,
+					last_error = 'lease expired', lock_by = NULL, lock_until = NULL, deferred = retry_count + 1 < $2, updated_at = NOW()
+				WHERE id IN (SELECT id FROM processing_task WHERE status IN `+taskRetryFrom+` AND lock_until < NOW() ORDER BY lock_until LIMIT $1 FOR UPDATE SKIP LOCKED)
+				RETURNING id, media_id, generation, step, status, retry_count
+			), closed AS (
+				UPDATE task_attempt SET finished_at = NOW(), outcome = 'LEASE_EXPIRED', error = 'lease expired'
+				WHERE finished_at IS NULL AND task_id IN (SELECT id FROM expired)
+			), h AS (
+				INSERT INTO media_event (media_id, kind, actor, payload)
+				SELECT media_id, CASE WHEN status = 'FAILED' THEN $4::text ELSE $3::text END, $5::text,
+					jsonb_build_object('task_id', id, 'generation', generation, 'step', step, 'retry_count', retry_count, 'error', 'lease expired')
+				FROM expired
+			)
+			SELECT media_id, generation, status = 'FAILED' FROM expired`,
+			limit, maxRetries, HistoryTaskLeaseExpired, HistoryTaskFailed, actorFrom(ctx),
+		)
+		if err != nil {
+			return err
+		}
+		type generation struct {
+			mediaID string
+			number  int
+		}
+		var toFail []generation
+		for rows.Next() {
+			var (
+				g     generation
+				fails bool
+			)
+			if err := rows.Scan(&g.mediaID, &g.number, &fails); err != nil {
+				rows.Close()
+				return err
+			}
+			if fails {
+				toFail = append(toFail, g)
+			} else {
+				requeued++
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, g := range toFail {
+			// Media that moved on to a newer generation, or was cancelled
+			// or deleted, is left alone.
+			err := failGeneration(ctx, tx, g.mediaID, g.number)
+			if err != nil && !errors.Is(err, ErrStaleGeneration) && !errors.Is(err, ErrIllegalTransition) {
+				return err
+			}
+		}
+		failed = len(toFail)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return requeued, failed, nil
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TenantQuota holds a tenant's limits; nil means the configured default.
+// Weight is the tenant's relative share of worker capacity and MaxInFlight
+// caps its dispatched and running tasks.
+type TenantQuota struct {
+	StorageBytes       *int64
+	MonthlyProcessRuns *int
+	Weight             int
+	MaxInFlight        *int
+}
+
+type TenantUsage struct {
+	StorageBytes       int64
+	MonthlyProcessRuns int
+}
+
+// EnsureTenant creates the tenant row if it does not exist yet.
+func EnsureTenant(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	_, err := pool.Exec(ctx,
+		"INSERT INTO tenant (id) VALUES ($1) ON CONFLICT DO NOTHING",
+		id,
+	)
+	return err
+}
+
+// GetTenantQuota returns the quotas of a tenant. A tenant without a row gets
+// the defaults.
+func GetTenantQuota(ctx context.Context, pool *pgxpool.Pool, id string) (*TenantQuota, error) {
+	q := &TenantQuota{Weight: 1}
+	err := pool.QueryRow(ctx,
+		"SELECT storage_quota_bytes, monthly_processing_quota, weight, max_in_flight FROM tenant WHERE id = $1",
+		id,
+	).Scan(&q.StorageBytes, &q.MonthlyProcessRuns, &q.Weight, &q.MaxInFlight)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetTenantQuota replaces the quotas of a tenant, creating it if needed.
+func SetTenantQuota(ctx context.Context, pool *pgxpool.Pool, id string, q TenantQuota) error {
+	_, err := pool.Exec(ctx,
+		`INSERT INTO tenant (id, storage_quota_bytes, monthly_processing_quota, weight, max_in_flight) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET storage_quota_bytes = EXCLUDED.storage_quota_bytes, monthly_processing_quota = EXCLUDED.monthly_processing_quota,
+			weight = EXCLUDED.weight, max_in_flight = EXCLUDED.max_in_flight`,
+		id, q.StorageBytes, q.MonthlyProcessRuns, max(q.Weight, 1), q.MaxInFlight,
+	)
+	return err
+}
+
+// GetTenantUsage returns the bytes of originals the tenant stores and the
+// pipeline runs it started this month.
+func GetTenantUsage(ctx context.Context, pool *pgxpool.Pool, id string) (*TenantUsage, error) {
+	u := &TenantUsage{}
+	err := pool.QueryRow(ctx,
+		`SELECT
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM media WHERE owner_id = $1 AND objects_purged_at IS NULL),
+			(SELECT COALESCE(SUM(processing_runs), 0) FROM tenant_usage WHERE tenant_id = $1 AND month = date_trunc('month', NOW() AT TIME ZONE 'UTC')::date)`,
+		id,
+	).Scan(&u.StorageBytes, &u.MonthlyProcessRuns)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ChargeProcessingRuns counts n pipeline runs against the tenant's monthly
+// quota if they fit, falling back to defaultQuota for tenants without their
+// own (0 means unlimited). It reports false, charging nothing, if they do
+// not. Check and charge are one statement, so concurrent requests cannot
+// overrun the quota between them.
+func ChargeProcessingRuns(ctx context.Context, pool *pgxpool.Pool, tenantID string, n int, defaultQuota int) (bool, error) {
+	var runs int
+	err := pool.QueryRow(ctx,
+		`WITH quota AS (
+			SELECT COALESCE((SELECT monthly_processing_quota FROM tenant WHERE id = $1), $3::int) AS runs
+		)
+		INSERT INTO tenant_usage (tenant_id, month, processing_runs)
+		SELECT $1, date_trunc('month', NOW() AT TIME ZONE 'UTC')::date, $2 FROM quota WHERE quota.runs <= 0 OR $2 <= quota.runs
+		ON CONFLICT (tenant_id, month) DO UPDATE SET processing_runs = tenant_usage.processing_runs + EXCLUDED.processing_runs
+		WHERE (SELECT runs FROM quota) <= 0 OR tenant_usage.processing_runs + EXCLUDED.processing_runs <= (SELECT runs FROM quota)
+		RETURNING processing_runs`,
+		tenantID, n, defaultQuota,
+	).Scan(&runs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RefundProcessingRuns returns n runs charged by ChargeProcessingRuns for
+// work that was then not started.
+func RefundProcessingRuns(ctx context.Context, pool *pgxpool.Pool, tenantID string, n int) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE tenant_usage SET processing_runs = GREATEST(processing_runs - $2, 0) WHERE tenant_id = $1 AND month = date_trunc('month', NOW() AT TIME ZONE 'UTC')::date",
+		tenantID, n,
+	)
+	return err
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/oklog/ulid/v2"
+)
+
+type WebhookDeliveryRow struct {
+	ID       string
+	MediaID  string
+	Event    string
+	URL      string
+	Payload  []byte
+	Attempts int
+}
+
+// enqueueWebhook records a delivery of event for the media's callback URL, if
+// it has one. The payload is a snapshot of the media row taken in the same
+// transaction as the state change that triggered it.
+func enqueueWebhook(ctx context.Context, tx pgx.Tx, mediaID string, event string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO webhook_delivery (id, media_id, event, url, payload)
+		SELECT $1, id, $2, callback_url, jsonb_build_object(
+			'event', $2::text,
+			'media_id', id,
+			'status', status,
+			'generation', generation,
+			'final_key', final_key,
+			'occurred_at', updated_at
+		)
+		FROM media WHERE id = $3 AND callback_url IS NOT NULL`,
+		ulid.Make().String(), event, mediaID,
+	)
+	return err
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries and pushes their
+// next attempt out by lease, so concurrent dispatchers do not send the same
+// delivery twice.
+func ClaimWebhookDeliveries(ctx context.Context, pool *pgxpool.Pool, limit int, lease time.Duration) ([]*WebhookDeliveryRow, error) {
+	rows, err := pool.Query(ctx,
+		"UPDATE webhook_delivery SET next_attempt_at = NOW() + ($2 * INTERVAL '1 second'), updated_at = NOW() WHERE id IN (SELECT id FROM webhook_delivery WHERE status = 'PENDING' AND next_attempt_at <= NOW() ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED) RETURNING id, media_id, event, url, payload, attempts",
+		limit, int(lease.Seconds()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDeliveryRow
+	for rows.Next() {
+		d := &WebhookDeliveryRow{}
+		if err := rows.Scan(&d.ID, &d.MediaID, &d.Event, &d.URL, &d.Payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func MarkWebhookDelivered(ctx context.Context, pool *pgxpool.Pool, id string, statusCode int) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE webhook_delivery SET status = 'DELIVERED', attempts = attempts + 1, last_status_code = $2, last_error = NULL, delivered_at = NOW(), updated_at = NOW() WHERE id = $1",
+		id, statusCode,
+	)
+	return err
+}
+
+// MarkWebhookRetry records a failed attempt and schedules the next one after backoff.
+func MarkWebhookRetry(ctx context.Context, pool *pgxpool.Pool, id string, statusCode *int, errMsg string, backoff time.Duration) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE webhook_delivery SET attempts = attempts + 1, last_status_code = $2, last_error = $3, next_attempt_at = NOW() + ($4 * INTERVAL '1 second'), updated_at = NOW() WHERE id = $1",
+		id, statusCode, errMsg, int(backoff.Seconds()),
+	)
+	return err
+}
+
+func MarkWebhookFailed(ctx context.Context, pool *pgxpool.Pool, id string, statusCode *int, errMsg string) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE webhook_delivery SET status = 'FAILED', attempts = attempts + 1, last_status_code = $2, last_error = $3, updated_at = NOW() WHERE id = $1",
+		id, statusCode, errMsg,
+	)
+	return err
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+)
+
+// Hub LISTENs on db.EventsChannel over a dedicated connection and fans
+// notifications out to subscribers of the affected media.
+type Hub struct {
+	pool *pgxpool.Pool
+
+	mu   sync.Mutex
+	subs map[string]map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	events chan db.MediaEvent
+	resync chan struct{}
+}
+
+func NewHub(pool *pgxpool.Pool) *Hub {
+	return &Hub{pool: pool, subs: make(map[string]map[*subscriber]struct{})}
+}
+
+// Subscribe returns a channel receiving events for mediaID, a channel
+// signalled when events may have been lost, and a function that must be
+// called to unsubscribe. Events are dropped for subscribers that fall behind
+// rather than blocking the listener, and notifications sent while the
+// listener reconnects never arrive; in both cases resync fires and the
+// subscriber should re-read the state it follows.
+func (h *Hub) Subscribe(mediaID string) (events <-chan db.MediaEvent, resync <-chan struct{}, unsubscribe func()) {
+	sub := &subscriber{events: make(chan db.MediaEvent, 32), resync: make(chan struct{}, 1)}
+
+	h.mu.Lock()
+	if h.subs[mediaID] == nil {
+		h.subs[mediaID] = make(map[*subscriber]struct{})
+	}
+	h.subs[mediaID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub.events, sub.resync, func() {
+		h.mu.Lock()
+		delete(h.subs[mediaID], sub)
+		if len(h.subs[mediaID]) == 0 {
+			delete(h.subs, mediaID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Run listens until ctx is done, reconnecting after errors.
+func (h *Hub) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := h.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("event listener failed: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) error {
+	pc, err := h.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Take the connection out of the pool so a LISTENing session is never
+	// handed to another caller.
+	conn := pc.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+db.EventsChannel); err != nil {
+		return err
+	}
+	// Anything sent since the previous session ended was missed.
+	h.resyncAll()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ev db.MediaEvent
+		if err := json.Unmarshal([]byte(n.Payload), &ev); err != nil {
+			log.Printf("bad event payload: %v", err)
+			continue
+		}
+		h.publish(ev)
+	}
+}
+
+func (h *Hub) publish(ev db.MediaEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[ev.MediaID] {
+		select {
+		case sub.events <- ev:
+		default:
+			sub.signalResync()
+		}
+	}
+}
+
+func (h *Hub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for sub := range subs {
+			sub.signalResync()
+		}
+	}
+}
+
+func (s *subscriber) signalResync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,239 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(t *testing.T, opts Options) *Fetcher {
+	t.Helper()
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = 1 << 20
+	}
+	if opts.AllowCIDRs == nil {
+		opts.AllowCIDRs = []string{"127.0.0.1/32"}
+	}
+	f, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	body, contentType, err := newTestFetcher(t, Options{}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "png" || contentType != "image/png" {
+		t.Fatalf("got %q, %q", body, contentType)
+	}
+}
+
+func TestFetchBlocksPrivateDestinations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(t, Options{AllowCIDRs: []string{}})
+	if _, _, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, ErrPermanent) {
+		t.Fatalf("err = %v, want ErrPermanent", err)
+	}
+}
+
+func TestFetchBlocksRedirectToPrivateDestination(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	if _, _, err := newTestFetcher(t, Options{}).Fetch(context.Background(), srv.URL); !errors.Is(err, ErrPermanent) {
+		t.Fatalf("err = %v, want ErrPermanent", err)
+	}
+}
+
+func TestFetchRedirectCap(t *testing.T) {
+	// /hop/n redirects n more times before answering.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n == 0 {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := newTestFetcher(t, Options{MaxRedirects: 2})
+	if _, _, err := f.Fetch(context.Background(), srv.URL+"/hop/2"); err != nil {
+		t.Fatalf("2 redirects: %v", err)
+	}
+	if _, _, err := f.Fetch(context.Background(), srv.URL+"/hop/3"); !errors.Is(err, ErrPermanent) {
+		t.Fatalf("3 redirects: err = %v, want ErrPermanent", err)
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sized", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before the end drops Content-Length, so only the read
+		// limit can catch the size.
+		_, _ = w.Write(body[:50])
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(body[50:])
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := newTestFetcher(t, Options{MaxBytes: 99})
+	for _, path := range []string{"/sized", "/chunked"} {
+		if _, _, err := f.Fetch(context.Background(), srv.URL+path); !errors.Is(err, ErrPermanent) {
+			t.Errorf("%s: err = %v, want ErrPermanent", path, err)
+		}
+	}
+
+	f = newTestFetcher(t, Options{MaxBytes: 100})
+	if _, _, err := f.Fetch(context.Background(), srv.URL+"/chunked"); err != nil {
+		t.Errorf("body at the limit: %v", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	f := newTestFetcher(t, Options{Timeout: 100 * time.Millisecond})
+	_, _, err := f.Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("fetch succeeded")
+	}
+	if errors.Is(err, ErrPermanent) {
+		t.Fatalf("timeout classified as permanent: %v", err)
+	}
+}
+
+func TestFetchStatusClassification(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{code}", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.PathValue("code"))
+		w.WriteHeader(code)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := newTestFetcher(t, Options{})
+	for _, tc := range []struct {
+		code      int
+		permanent bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusForbidden, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+	} {
+		_, _, err := f.Fetch(context.Background(), srv.URL+"/"+strconv.Itoa(tc.code))
+		if err == nil {
+			t.Errorf("%d: fetch succeeded", tc.code)
+			continue
+		}
+		if errors.Is(err, ErrPermanent) != tc.permanent {
+			t.Errorf("%d: permanent = %v, want %v (%v)", tc.code, !tc.permanent, tc.permanent, err)
+		}
+	}
+
+	if _, _, err := f.Fetch(context.Background(), "ftp://example.com/a.jpg"); !errors.Is(err, ErrPermanent) {
+		t.Errorf("ftp scheme: err = %v, want ErrPermanent", err)
+	}
+}
+
+func TestIsBlocked(t *testing.T) {
+	for _, tc := range []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"192.0.0.8", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"198.20.0.1", false},
+		{"192.0.2.1", false},
+		{"2606:4700::1111", false},
+	} {
+		if got := isBlocked(net.ParseIP(tc.ip)); got != tc.blocked {
+			t.Errorf("isBlocked(%s) = %v, want %v", tc.ip, got, tc.blocked)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	allow, err := ParseCIDRs([]string{"127.0.0.1/32", " "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host  string
+		allow bool
+		ok    bool
+	}{
+		{"example.com", false, true},
+		{"8.8.8.8", false, true},
+		{"localhost", false, false},
+		{"api.localhost.", false, false},
+		{"127.0.0.1", false, false},
+		{"169.254.169.254", false, false},
+		{"::1", false, false},
+		{"localhost", true, true},
+		{"127.0.0.1", true, true},
+		{"10.0.0.1", true, false},
+	} {
+		var nets []*net.IPNet
+		if tc.allow {
+			nets = allow
+		}
+		if err := CheckHost(tc.host, nets); (err == nil) != tc.ok {
+			t.Errorf("CheckHost(%q, allow=%v) = %v, want ok=%v", tc.host, tc.allow, err, tc.ok)
+		}
+	}
+}
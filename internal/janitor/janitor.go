@@ -0,0 +1,100 @@
+package janitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+	"sys-design/internal/storage"
+)
+
+// Janitor expires media whose upload was never completed, removes objects of
+// expired and deleted media, and hard-deletes deleted media once their grace
+// period has passed. It also drops stored idempotent responses past their TTL.
+type Janitor struct {
+	DB             *pgxpool.Pool
+	Store          *storage.MinioStore
+	UploadTTL      time.Duration
+	IdempotencyTTL time.Duration
+	Interval       time.Duration
+	BatchSize      int
+}
+
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunOnce(ctx); err != nil {
+			obs.JanitorErrors.Inc()
+			log.Printf("janitor run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Janitor) RunOnce(ctx context.Context) error {
+	expired, err := db.ExpireStaleMedia(ctx, j.DB, j.UploadTTL, j.BatchSize)
+	if err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		log.Printf("janitor expired %d media", len(expired))
+		obs.MediaExpired.Add(float64(len(expired)))
+	}
+
+	refs, err := db.ListUnpurgedMedia(ctx, j.DB, j.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, m := range refs {
+		// Objects are removed before the row is marked, so a failed purge is
+		// picked up again on the next run.
+		n, err := j.Store.DeletePrefix(ctx, storage.MediaPrefix(m.TenantID, m.ID))
+		obs.OrphanObjectsDeleted.Add(float64(n))
+		if err != nil {
+			obs.JanitorErrors.Inc()
+			log.Printf("janitor purge %s failed: %v", m.ID, err)
+			continue
+		}
+		if err := db.MarkMediaPurged(ctx, j.DB, m.ID); err != nil {
+			obs.JanitorErrors.Inc()
+			log.Printf("janitor mark purged %s failed: %v", m.ID, err)
+		}
+	}
+
+	refs, err = db.ListPurgeableMedia(ctx, j.DB, j.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, m := range refs {
+		// Sweep the prefix once more in case a worker wrote an output after
+		// the first purge.
+		n, err := j.Store.DeletePrefix(ctx, storage.MediaPrefix(m.TenantID, m.ID))
+		obs.OrphanObjectsDeleted.Add(float64(n))
+		if err != nil {
+			obs.JanitorErrors.Inc()
+			log.Printf("janitor purge %s failed: %v", m.ID, err)
+			continue
+		}
+		if err := db.HardDeleteMedia(ctx, j.DB, m.ID); err != nil {
+			obs.JanitorErrors.Inc()
+			log.Printf("janitor hard delete %s failed: %v", m.ID, err)
+			continue
+		}
+		obs.MediaHardDeleted.Inc()
+	}
+
+	if _, err := db.DeleteExpiredIdempotencyKeys(ctx, j.DB, j.IdempotencyTTL); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+// Package migrate applies the embedded schema migrations and records them in
+// schema_migrations. Runs hold a Postgres advisory lock, so an API replica
+// migrating on start and cmd/migrate never apply the same migration twice.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockKey is the advisory lock held for the duration of a run.
+const lockKey = 0x5c4e0a
+
+// Migration is one schema version. Down is empty for migrations that cannot
+// be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// Status is a migration and when it was applied, nil if it is pending.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+?)(\.down)?\.sql$`)
+
+// Load reads NNN_name.sql and NNN_name.down.sql files from fsys and returns
+// the migrations ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	downs := map[int]string{}
+	for _, e := range entries {
+		match := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] != "" {
+			downs[version] = string(body)
+			continue
+		}
+		if prev, ok := byVersion[version]; ok {
+			return nil, fmt.Errorf("migrate: %s and %s share version %d", prev, e.Name(), version)
+		}
+		byVersion[version] = &Migration{Version: version, Name: match[2], Up: string(body)}
+	}
+	for version, down := range downs {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migrate: down migration %03d has no up migration", version)
+		}
+		m.Down = down
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the ones it applied. A migration that fails is
+// rolled back and stops the run.
+func Up(ctx context.Context, pool *pgxpool.Pool, migrations []Migration) ([]Migration, error) {
+	var applied []Migration
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if err := run(ctx, conn, m.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name,
+			); err != nil {
+				return fmt.Errorf("migrate: apply %s: %w", m, err)
+			}
+			log.Printf("applied migration %s", m)
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the steps most recently applied migrations, newest first, and
+// returns the ones it reverted. Asking for more steps than there are applied
+// migrations is an error and reverts nothing. It stops at a migration
+// without a down migration or one missing from migrations.
+func Down(ctx context.Context, pool *pgxpool.Pool, migrations []Migration, steps int) ([]Migration, error) {
+	known := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	var reverted []Migration
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(done))
+		for v := range done {
+			versions = append(versions, v)
+		}
+		if steps > len(versions) {
+			return fmt.Errorf("migrate: cannot revert %d migrations, only %d applied", steps, len(versions))
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, v := range versions[:steps] {
+			m, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migrate: applied version %d is unknown to this build", v)
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migrate: %s cannot be reverted", m)
+			}
+			if err := run(ctx, conn, m.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", m.Version,
+			); err != nil {
+				return fmt.Errorf("migrate: revert %s: %w", m, err)
+			}
+			log.Printf("reverted migration %s", m)
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Statuses reports whether each migration has been applied.
+func Statuses(ctx context.Context, pool *pgxpool.Pool, migrations []Migration) ([]Status, error) {
+	var statuses []Status
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			s := Status{Migration: m}
+			if at, ok := done[m.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on one connection holding the migration lock, creating
+// schema_migrations first if needed.
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return err
+	}
+	defer func() {
+		// Unlock even if ctx was cancelled; a connection returned to the
+		// pool still holding the lock would block every later run.
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	if _, err := conn.Exec(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+	); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedVersions returns the applied versions and when they were applied.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			v  int
+			at time.Time
+		)
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		done[v] = at
+	}
+	return done, rows.Err()
+}
+
+// run executes script and the bookkeeping statement record in one
+// transaction. script is sent without arguments, so it may hold several
+// statements.
+func run(ctx context.Context, conn *pgxpool.Conn, script string, record string, args ...any) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+}
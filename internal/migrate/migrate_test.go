@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_tenants.sql":        file("create tenant"),
+		"002_media.sql":          file("create media"),
+		"002_media.down.sql":     file("drop media"),
+		"001_init.sql":           file("create task"),
+		"README.md":              file("not a migration"),
+		"003_Bad-Name.sql":       file("skipped"),
+		"004_no_extension":       file("skipped"),
+		"x_missing_version.sql":  file("skipped"),
+		"005_sub/006_nested.sql": file("skipped"),
+	}
+	got, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "init", Up: "create task"},
+		{Version: 2, Name: "media", Up: "create media", Down: "drop media"},
+		{Version: 10, Name: "tenants", Up: "create tenant"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+	if got[2].String() != "010_tenants" {
+		t.Errorf("String = %q, want 010_tenants", got[2].String())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"001_init.sql":  file("a"),
+				"001_other.sql": file("b"),
+			},
+			want: "share version 1",
+		},
+		{
+			name: "duplicate version with different padding",
+			fsys: fstest.MapFS{
+				"001_init.sql": file("a"),
+				"1_other.sql":  file("b"),
+			},
+			want: "share version 1",
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{
+				"001_init.sql":       file("a"),
+				"002_media.down.sql": file("b"),
+			},
+			want: "down migration 002 has no up migration",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(tc.fsys)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Load error = %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	got, err := Load(fstest.MapFS{})
+	if err != nil || len(got) != 0 {
+		t.Errorf("Load = %v, %v; want no migrations", got, err)
+	}
+}
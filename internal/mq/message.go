@@ -0,0 +1,98 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// SchemaVersion is the task message schema written by this build, as
+// "major.minor". Minor versions only add fields, so a consumer accepts any
+// message with its major version and ignores fields it does not know.
+const SchemaVersion = "1.0"
+
+// TaskMessageType is the AMQP type property of task messages.
+const TaskMessageType = "processing.task"
+
+// ErrUnsupportedVersion is returned by DecodeTask for messages written with
+// a schema major version this build cannot read.
+var ErrUnsupportedVersion = errors.New("unsupported task message schema version")
+
+// TaskMessage asks a worker to run one task. Messages without a version
+// predate versioning and are read as 1.0.
+type TaskMessage struct {
+	SchemaVersion string    `json:"schema_version"`
+	MessageID     string    `json:"message_id"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	TaskID     string `json:"task_id"`
+	MediaID    string `json:"media_id"`
+	Generation int    `json:"generation"`
+	Step       string `json:"step"`
+	Priority   int    `json:"priority"`
+	// Attempt is 1 for the first run of the task and grows with each retry.
+	Attempt int `json:"attempt"`
+
+	CorrelationID string `json:"correlation_id,omitempty"`
+	TraceID       string `json:"trace_id,omitempty"`
+}
+
+func (m *TaskMessage) headers() amqp.Table {
+	h := amqp.Table{
+		"schema-version": m.SchemaVersion,
+		"task-id":        m.TaskID,
+		"media-id":       m.MediaID,
+		"generation":     int32(m.Generation),
+		"step":           m.Step,
+		"attempt":        int32(m.Attempt),
+	}
+	if m.TraceID != "" {
+		h["trace-id"] = m.TraceID
+	}
+	return h
+}
+
+// DecodeTask reads a task message from a delivery. The schema version comes
+// from the body, or the schema-version header if the body has none. It
+// returns ErrUnsupportedVersion for an unknown major version.
+func DecodeTask(d amqp.Delivery) (TaskMessage, error) {
+	var msg TaskMessage
+	if err := json.Unmarshal(d.Body, &msg); err != nil {
+		return msg, err
+	}
+	if msg.SchemaVersion == "" {
+		msg.SchemaVersion, _ = d.Headers["schema-version"].(string)
+	}
+	if msg.SchemaVersion == "" {
+		msg.SchemaVersion = "1.0"
+	}
+	if major(msg.SchemaVersion) != major(SchemaVersion) {
+		return msg, fmt.Errorf("%w: %s", ErrUnsupportedVersion, msg.SchemaVersion)
+	}
+	if msg.TaskID == "" {
+		return msg, errors.New("task message without task_id")
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = d.MessageId
+	}
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = d.CorrelationId
+	}
+	return msg, nil
+}
+
+// major returns the major part of a "major.minor" version, or -1 if it is
+// not a number.
+func major(version string) int {
+	v, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return -1
+	}
+	return n
+}
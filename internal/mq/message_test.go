@@ -0,0 +1,111 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDecodeTask(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		body        string
+		headers     amqp.Table
+		wantVersion string
+		wantErr     error
+		anyErr      bool
+	}{
+		{
+			name:        "unversioned message is 1.0",
+			body:        `{"task_id":"t1","step":"resize"}`,
+			wantVersion: "1.0",
+		},
+		{
+			name:        "version from the header",
+			body:        `{"task_id":"t1"}`,
+			headers:     amqp.Table{"schema-version": "1.3"},
+			wantVersion: "1.3",
+		},
+		{
+			name:        "body version wins over the header",
+			body:        `{"schema_version":"1.1","task_id":"t1"}`,
+			headers:     amqp.Table{"schema-version": "2.0"},
+			wantVersion: "1.1",
+		},
+		{
+			name:        "newer minor version",
+			body:        `{"schema_version":"1.7","task_id":"t1"}`,
+			wantVersion: "1.7",
+		},
+		{
+			name:        "unknown fields are ignored",
+			body:        `{"schema_version":"1.2","task_id":"t1","region":"eu","retries":{"max":3}}`,
+			wantVersion: "1.2",
+		},
+		{
+			name:    "newer major version",
+			body:    `{"schema_version":"2.0","task_id":"t1"}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "newer major version in the header",
+			body:    `{"task_id":"t1"}`,
+			headers: amqp.Table{"schema-version": "2.1"},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "non-numeric major version",
+			body:    `{"schema_version":"v1.0","task_id":"t1"}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:   "missing task_id",
+			body:   `{"schema_version":"1.0","step":"resize"}`,
+			anyErr: true,
+		},
+		{
+			name:   "malformed body",
+			body:   `{"task_id":`,
+			anyErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := DecodeTask(amqp.Delivery{Body: []byte(tc.body), Headers: tc.headers})
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tc.wantErr)
+				}
+			case tc.anyErr:
+				if err == nil {
+					t.Fatal("decoded without error")
+				}
+				if errors.Is(err, ErrUnsupportedVersion) {
+					t.Fatalf("err = %v, want a malformed-message error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.SchemaVersion != tc.wantVersion || msg.TaskID != "t1" {
+					t.Errorf("msg = %+v, want version %s, task t1", msg, tc.wantVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTaskFallsBackToProperties(t *testing.T) {
+	msg, err := DecodeTask(amqp.Delivery{
+		Body:          []byte(`{"task_id":"t1"}`),
+		MessageId:     "msg-1",
+		CorrelationId: "corr-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageID != "msg-1" || msg.CorrelationID != "corr-1" {
+		t.Errorf("msg = %+v, want message and correlation IDs from the properties", msg)
+	}
+}
@@ -2,23 +2,28 @@ package mq
 
 import (
 	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/oklog/ulid/v2"
 	amqp "github.com/rabbitmq/amqp091-go"
 
 	"sys-design/internal/config"
+	"sys-design/internal/pipeline"
 )
 
+// Publisher sends tasks to a topic exchange with routing key task.<step>.
+// Every step has its own queue, so workers can be scaled per step.
 type Publisher struct {
-	Conn  *amqp.Connection
-	Ch    *amqp.Channel
-	Queue string
+	Conn        *amqp.Connection
+	Ch          *amqp.Channel
+	Exchange    string
+	QueuePrefix string
 }
 
-type TaskMessage struct {
-	TaskID  string `json:"task_id"`
-	MediaID string `json:"media_id"`
-	Step    string `json:"step"`
-}
+// MaxPriority is the highest task priority; step queues are declared with it
+// as x-max-priority.
+const MaxPriority = 9
 
 func NewPublisher(cfg *config.Config) (*Publisher, error) {
 	conn, err := amqp.Dial(cfg.RabbitURL())
@@ -31,21 +36,83 @@ func NewPublisher(cfg *config.Config) (*Publisher, error) {
 		return nil, err
 	}
 
-	_, err = ch.QueueDeclare(
-		cfg.RabbitQueue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
+	// Declare every step queue up front: the exchange drops messages that
+	// match no bound queue, e.g. before any worker for that step has started.
+	if err := DeclareTopology(ch, cfg.RabbitExchange, cfg.RabbitQueue, pipeline.AllSteps); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	// Publisher confirms let PublishTask report a message the broker did not
+	// take, so its task is not marked dispatched.
+	if err := ch.Confirm(false); err != nil {
 		ch.Close()
 		conn.Close()
 		return nil, err
 	}
 
-	return &Publisher{Conn: conn, Ch: ch, Queue: cfg.RabbitQueue}, nil
+	return &Publisher{Conn: conn, Ch: ch, Exchange: cfg.RabbitExchange, QueuePrefix: cfg.RabbitQueue}, nil
+}
+
+// RoutingKey is the routing key of tasks for step.
+func RoutingKey(step string) string {
+	return "task." + step
+}
+
+// QueueName is the queue that holds tasks for step.
+func QueueName(prefix, step string) string {
+	return prefix + "." + step
+}
+
+// DeadLetterExchange is the fanout exchange rejected task messages are
+// routed to.
+func DeadLetterExchange(exchange string) string {
+	return exchange + ".dlx"
+}
+
+// DeadLetterQueue holds task messages rejected by workers: malformed bodies,
+// unsupported schema versions and tasks that failed for good.
+func DeadLetterQueue(prefix string) string {
+	return prefix + ".dead"
+}
+
+// DeclareTopology declares the durable topic exchange and, for each step, a
+// priority queue bound to it that dead-letters rejected messages, plus the
+// dead-letter exchange and queue. The arguments must match on every declare,
+// so the API and workers share this.
+func DeclareTopology(ch *amqp.Channel, exchange, queuePrefix string, steps []string) error {
+	dlx := DeadLetterExchange(exchange)
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(DeadLetterQueue(queuePrefix), true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(DeadLetterQueue(queuePrefix), "", dlx, false, nil); err != nil {
+		return err
+	}
+
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return err
+	}
+	for _, step := range steps {
+		queue := QueueName(queuePrefix, step)
+		if _, err := ch.QueueDeclare(
+			queue,
+			true,
+			false,
+			false,
+			false,
+			amqp.Table{"x-max-priority": MaxPriority, "x-dead-letter-exchange": dlx},
+		); err != nil {
+			return err
+		}
+		if err := ch.QueueBind(queue, RoutingKey(step), exchange, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (p *Publisher) Close() {
@@ -57,20 +124,46 @@ func (p *Publisher) Close() {
 	}
 }
 
+// ErrNotConfirmed is returned when the broker nacks a published task or the
+// channel closes before confirming it.
+var ErrNotConfirmed = errors.New("mq: publish not confirmed")
+
+// PublishTask stamps msg with the current schema version, a fresh message
+// ID and the publish time, and publishes it as a persistent message. The
+// envelope fields are also set as AMQP properties and headers so they can be
+// read without decoding the body. It returns once the broker has confirmed
+// the message.
 func (p *Publisher) PublishTask(msg TaskMessage) error {
+	msg.SchemaVersion = SchemaVersion
+	msg.MessageID = ulid.Make().String()
+	msg.CreatedAt = time.Now().UTC()
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	return p.Ch.Publish(
-		"",
-		p.Queue,
+	confirm, err := p.Ch.PublishWithDeferredConfirm(
+		p.Exchange,
+		RoutingKey(msg.Step),
 		false,
 		false,
 		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
+			ContentType:   "application/json",
+			DeliveryMode:  amqp.Persistent,
+			Priority:      uint8(min(max(msg.Priority, 0), MaxPriority)),
+			MessageId:     msg.MessageID,
+			CorrelationId: msg.CorrelationID,
+			Timestamp:     msg.CreatedAt,
+			Type:          TaskMessageType,
+			Headers:       msg.headers(),
+			Body:          body,
 		},
 	)
+	if err != nil {
+		return err
+	}
+	if !confirm.Wait() {
+		return ErrNotConfirmed
+	}
+	return nil
 }
@@ -60,6 +60,120 @@ var (
 			Help: "Total tasks failed.",
 		},
 	)
+	TasksCancelled = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tasks_cancelled_total",
+			Help: "Total running tasks aborted by the worker after cancellation.",
+		},
+	)
+
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total webhook delivery attempts by outcome.",
+		},
+		[]string{"outcome"},
+	)
+
+	MediaExpired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "media_expired_total",
+			Help: "Total media expired because the upload was never completed.",
+		},
+	)
+	OrphanObjectsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "orphan_objects_deleted_total",
+			Help: "Total objects removed by the janitor.",
+		},
+	)
+	MediaHardDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "media_hard_deleted_total",
+			Help: "Total deleted media removed from the database.",
+		},
+	)
+	JanitorErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "janitor_errors_total",
+			Help: "Total janitor failures.",
+		},
+	)
+
+	QuotaRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_rejections_total",
+			Help: "Total requests refused because a tenant quota was exhausted.",
+		},
+		[]string{"quota"},
+	)
+	RateLimitDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_decisions_total",
+			Help: "Total rate limit checks by policy and outcome (allowed, rejected).",
+		},
+		[]string{"policy", "outcome"},
+	)
+
+	TasksDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_dead_lettered_total",
+			Help: "Total task messages rejected to the dead-letter queue, by reason.",
+		},
+		[]string{"reason"},
+	)
+	TasksLeaseExpired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tasks_lease_expired_total",
+			Help: "Total RUNNING tasks requeued by the sweeper after their lease expired.",
+		},
+	)
+	TasksRedispatched = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tasks_redispatched_total",
+			Help: "Total dispatched tasks returned to the scheduler after their message went unclaimed.",
+		},
+	)
+	TasksFenced = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tasks_fenced_total",
+			Help: "Total task writes refused because the worker's lease epoch was superseded.",
+		},
+	)
+	TasksDispatched = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tasks_dispatched_total",
+			Help: "Total tasks published by the scheduler, by tenant.",
+		},
+		[]string{"tenant"},
+	)
+	TaskQueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "task_queue_wait_seconds",
+			Help:    "Time from a task becoming ready until the scheduler dispatched it, by tenant.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600},
+		},
+		[]string{"tenant"},
+	)
+
+	AdmissionOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "admission_open",
+			Help: "1 while the API admits new pipeline runs, 0 while it sheds load.",
+		},
+	)
+	TaskBacklog = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "task_backlog",
+			Help: "PENDING/RETRY tasks at the last admission check.",
+		},
+	)
+	AdmissionRejections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "admission_rejections_total",
+			Help: "Total pipeline runs refused while shedding load.",
+		},
+	)
 )
 
 func RegisterAll() {
@@ -72,6 +186,23 @@ func RegisterAll() {
 		TasksSkipped,
 		TasksRetried,
 		TasksFailed,
+		TasksCancelled,
+		WebhookDeliveries,
+		MediaExpired,
+		OrphanObjectsDeleted,
+		MediaHardDeleted,
+		JanitorErrors,
+		QuotaRejections,
+		RateLimitDecisions,
+		TasksDeadLettered,
+		TasksLeaseExpired,
+		TasksRedispatched,
+		TasksFenced,
+		TasksDispatched,
+		TaskQueueWait,
+		AdmissionOpen,
+		TaskBacklog,
+		AdmissionRejections,
 	)
 }
 
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+
+	"sys-design/internal/storage"
+)
+
+// Profile is a named, ordered list of processing steps. Each step reads the
+// previous step's output; the first step reads the original upload. Priority
+// applies to uploads with the profile unless the request sets one.
+type Profile struct {
+	Name     string
+	Steps    []string
+	Priority int
+}
+
+const DefaultProfile = "default"
+
+// AllSteps lists every step a task can run, including "fetch", which imports
+// prepend to a profile's steps.
+var AllSteps = []string{"fetch", "resize", "compress", "webp"}
+
+// Task priorities, 0-9 with higher served first. Interactive uploads go ahead
+// of bulk work such as back-catalogue reprocessing.
+const (
+	PriorityBulk        = 1
+	PriorityInteractive = 5
+)
+
+var profiles = map[string]Profile{
+	"default":   {Name: "default", Steps: []string{"resize", "compress", "webp"}, Priority: PriorityInteractive},
+	"thumbnail": {Name: "thumbnail", Steps: []string{"resize", "webp"}, Priority: PriorityInteractive},
+}
+
+func LookupProfile(name string) (Profile, bool) {
+	p, ok := profiles[name]
+	return p, ok
+}
+
+// SelectSteps returns the subset of the profile's steps named in only, kept in
+// profile order. An empty only selects every step. The subset must include the
+// profile's first step: the first task reads the original upload, which later
+// steps were never meant to take as input.
+func (p Profile) SelectSteps(only []string) ([]string, error) {
+	if len(only) == 0 {
+		return p.Steps, nil
+	}
+	want := make(map[string]bool, len(only))
+	for _, step := range only {
+		if !slices.Contains(p.Steps, step) {
+			return nil, fmt.Errorf("step %q is not part of profile %q", step, p.Name)
+		}
+		want[step] = true
+	}
+	if !want[p.Steps[0]] {
+		return nil, fmt.Errorf("steps must include %q, the first step of profile %q", p.Steps[0], p.Name)
+	}
+	var steps []string
+	for _, step := range p.Steps {
+		if want[step] {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+// Next returns the step that follows step in steps.
+func Next(steps []string, step string) (string, bool) {
+	for i, s := range steps {
+		if s == step && i+1 < len(steps) {
+			return steps[i+1], true
+		}
+	}
+	return "", false
+}
+
+// OutputKey is the deterministic object key a step writes for a generation.
+// Keys are versioned by generation so a reprocess never collides with (or is
+// skipped because of) an earlier run's outputs.
+func OutputKey(tenantID, mediaID string, generation int, step string) string {
+	ext := ".jpg"
+	if step == "webp" {
+		ext = ".webp"
+	}
+	return storage.MediaPrefix(tenantID, mediaID) + "v" + strconv.Itoa(generation) + "/" + step + ext
+}
+
+// AttemptKey is where the attempt holding lease epoch writes a step's output
+// before promoting it to outputKey. Only the worker still holding that epoch
+// promotes, so a worker whose lease was taken over never writes outputKey.
+func AttemptKey(outputKey string, epoch int64) string {
+	return path.Dir(outputKey) + "/attempts/" + strconv.FormatInt(epoch, 10) + "/" + path.Base(outputKey)
+}
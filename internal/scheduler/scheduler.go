@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+	"sys-design/internal/mq"
+	"sys-design/internal/obs"
+	"sys-design/internal/pipeline"
+)
+
+// Scheduler publishes tasks held in Postgres so that tenants share worker
+// capacity by weight. Each step queue is kept topped up to MaxQueuedPerStep
+// messages, and every free slot goes to the tenant with the fewest in-flight
+// tasks per unit of weight, so a tenant with a huge backlog cannot starve
+// the others. A tenant's in-flight tasks never exceed its max_in_flight, or
+// DefaultMaxInFlight if it has none; 0 means uncapped.
+type Scheduler struct {
+	DB                 *pgxpool.Pool
+	Publisher          *mq.Publisher
+	Interval           time.Duration
+	MaxQueuedPerStep   int
+	DefaultMaxInFlight int
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RunOnce(ctx); err != nil {
+				log.Printf("scheduler run failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	now := time.Now()
+	n, err := db.DispatchTasks(ctx, s.DB, s.plan, func(tenantID string, t *db.ProcessingTaskRow) error {
+		msg := mq.TaskMessage{
+			TaskID:     t.ID,
+			MediaID:    t.MediaID,
+			Generation: t.Generation,
+			Step:       t.Step,
+			Priority:   t.Priority,
+			Attempt:    t.RetryCount + 1,
+		}
+		if t.CorrelationID != nil {
+			msg.CorrelationID = *t.CorrelationID
+		}
+		if t.TraceID != nil {
+			msg.TraceID = *t.TraceID
+		}
+		if err := s.Publisher.PublishTask(msg); err != nil {
+			return err
+		}
+		obs.TasksDispatched.WithLabelValues(tenantID).Inc()
+		obs.TaskQueueWait.WithLabelValues(tenantID).Observe(max(0, now.Sub(t.ReadyAt()).Seconds()))
+		return nil
+	})
+	if n > 0 {
+		obs.TasksPublished.Add(float64(n))
+	}
+	return err
+}
+
+// plan fills the free slots of each step queue one at a time, handing each
+// to the eligible tenant that is furthest below its weighted share.
+func (s *Scheduler) plan(queued map[string]int, loads []*db.TenantLoad) db.DispatchPlan {
+	p := db.DispatchPlan{}
+	for _, step := range pipeline.AllSteps {
+		for free := s.MaxQueuedPerStep - queued[step]; free > 0; free-- {
+			l := s.pick(step, loads)
+			if l == nil {
+				break
+			}
+			l.Ready[step]--
+			l.InFlight++
+			if p[step] == nil {
+				p[step] = map[string]int{}
+			}
+			p[step][l.TenantID]++
+		}
+	}
+	return p
+}
+
+// pick returns the tenant with ready tasks for step and room under its cap
+// that has the lowest in-flight count relative to its weight. Ties go to the
+// lower tenant ID so plans are deterministic.
+func (s *Scheduler) pick(step string, loads []*db.TenantLoad) *db.TenantLoad {
+	var best *db.TenantLoad
+	var bestShare float64
+	for _, l := range loads {
+		if l.Ready[step] <= 0 {
+			continue
+		}
+		limit := s.DefaultMaxInFlight
+		if l.MaxInFlight != nil {
+			limit = *l.MaxInFlight
+		}
+		if limit > 0 && l.InFlight >= limit {
+			continue
+		}
+		share := float64(l.InFlight) / float64(max(l.Weight, 1))
+		if best == nil || share < bestShare || (share == bestShare && l.TenantID < best.TenantID) {
+			best, bestShare = l, share
+		}
+	}
+	return best
+}
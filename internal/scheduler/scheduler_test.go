@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"sys-design/internal/db"
+)
+
+func intPtr(n int) *int { return &n }
+
+func load(tenant string, weight, inFlight int, ready map[string]int) *db.TenantLoad {
+	return &db.TenantLoad{TenantID: tenant, Weight: weight, InFlight: inFlight, Ready: ready}
+}
+
+func TestPlan(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		s          Scheduler
+		queued     map[string]int
+		loads      []*db.TenantLoad
+		want       db.DispatchPlan
+		wantFlight map[string]int
+	}{
+		{
+			name: "small tenant is not starved by a large backlog",
+			s:    Scheduler{MaxQueuedPerStep: 4},
+			loads: []*db.TenantLoad{
+				load("big", 1, 0, map[string]int{"resize": 100000}),
+				load("small", 1, 0, map[string]int{"resize": 2}),
+			},
+			want:       db.DispatchPlan{"resize": {"big": 2, "small": 2}},
+			wantFlight: map[string]int{"big": 2, "small": 2},
+		},
+		{
+			name: "in-flight work counts against the share",
+			s:    Scheduler{MaxQueuedPerStep: 4},
+			loads: []*db.TenantLoad{
+				load("big", 1, 3, map[string]int{"resize": 100}),
+				load("small", 1, 0, map[string]int{"resize": 100}),
+			},
+			want:       db.DispatchPlan{"resize": {"big": 1, "small": 3}},
+			wantFlight: map[string]int{"big": 4, "small": 3},
+		},
+		{
+			name: "weights 2:1",
+			s:    Scheduler{MaxQueuedPerStep: 6},
+			loads: []*db.TenantLoad{
+				load("a", 2, 0, map[string]int{"resize": 100}),
+				load("b", 1, 0, map[string]int{"resize": 100}),
+			},
+			want:       db.DispatchPlan{"resize": {"a": 4, "b": 2}},
+			wantFlight: map[string]int{"a": 4, "b": 2},
+		},
+		{
+			name:   "free slots are what the queue lacks",
+			s:      Scheduler{MaxQueuedPerStep: 4},
+			queued: map[string]int{"resize": 3, "webp": 4},
+			loads: []*db.TenantLoad{
+				load("a", 1, 0, map[string]int{"resize": 10, "webp": 10}),
+			},
+			want:       db.DispatchPlan{"resize": {"a": 1}},
+			wantFlight: map[string]int{"a": 1},
+		},
+		{
+			name: "max_in_flight caps a tenant across steps",
+			s:    Scheduler{MaxQueuedPerStep: 10},
+			loads: []*db.TenantLoad{
+				{TenantID: "a", Weight: 1, MaxInFlight: intPtr(3), Ready: map[string]int{"resize": 2, "compress": 2}},
+				load("b", 1, 0, map[string]int{"resize": 1}),
+			},
+			want:       db.DispatchPlan{"resize": {"a": 2, "b": 1}, "compress": {"a": 1}},
+			wantFlight: map[string]int{"a": 3, "b": 1},
+		},
+		{
+			name: "DefaultMaxInFlight applies without a tenant cap",
+			s:    Scheduler{MaxQueuedPerStep: 10, DefaultMaxInFlight: 2},
+			loads: []*db.TenantLoad{
+				load("a", 1, 1, map[string]int{"resize": 10}),
+				{TenantID: "b", Weight: 1, MaxInFlight: intPtr(5), Ready: map[string]int{"resize": 10}},
+			},
+			want:       db.DispatchPlan{"resize": {"a": 1, "b": 5}},
+			wantFlight: map[string]int{"a": 2, "b": 5},
+		},
+		{
+			name: "a tenant cap of 0 lifts the default",
+			s:    Scheduler{MaxQueuedPerStep: 3, DefaultMaxInFlight: 1},
+			loads: []*db.TenantLoad{
+				{TenantID: "a", Weight: 1, MaxInFlight: intPtr(0), Ready: map[string]int{"resize": 10}},
+			},
+			want:       db.DispatchPlan{"resize": {"a": 3}},
+			wantFlight: map[string]int{"a": 3},
+		},
+		{
+			name:  "nothing ready",
+			s:     Scheduler{MaxQueuedPerStep: 4},
+			loads: []*db.TenantLoad{load("a", 1, 5, map[string]int{})},
+			want:  db.DispatchPlan{},
+		},
+		{
+			name:   "queues full",
+			s:      Scheduler{MaxQueuedPerStep: 2},
+			queued: map[string]int{"fetch": 2, "resize": 2, "compress": 3, "webp": 2},
+			loads: []*db.TenantLoad{
+				load("a", 1, 0, map[string]int{"fetch": 1, "resize": 1, "compress": 1, "webp": 1}),
+			},
+			want: db.DispatchPlan{},
+		},
+		{
+			name: "no tenants",
+			s:    Scheduler{MaxQueuedPerStep: 4},
+			want: db.DispatchPlan{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.s.plan(tc.queued, tc.loads)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("plan = %v, want %v", got, tc.want)
+			}
+			for _, l := range tc.loads {
+				if want, ok := tc.wantFlight[l.TenantID]; ok && l.InFlight != want {
+					t.Errorf("%s in flight = %d, want %d", l.TenantID, l.InFlight, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPickBreaksTiesByTenantID(t *testing.T) {
+	s := &Scheduler{}
+	loads := []*db.TenantLoad{
+		load("c", 1, 1, map[string]int{"resize": 1}),
+		load("b", 1, 1, map[string]int{"resize": 1}),
+		load("a", 2, 4, map[string]int{"resize": 1}),
+	}
+	if got := s.pick("resize", loads); got.TenantID != "b" {
+		t.Errorf("pick = %s, want b", got.TenantID)
+	}
+	if got := s.pick("webp", loads); got != nil {
+		t.Errorf("pick for a step without ready tasks = %s, want nil", got.TenantID)
+	}
+}
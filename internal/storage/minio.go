@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"net/url"
 	"time"
@@ -79,6 +80,66 @@ func (s *MinioStore) ObjectExists(ctx context.Context, objectKey string) (bool,
 	return false, err
 }
 
+// ObjectSize returns the size of objectKey in bytes and false if it does not exist.
+func (s *MinioStore) ObjectSize(ctx context.Context, objectKey string) (int64, bool, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, objectKey, minio.StatObjectOptions{})
+	if err == nil {
+		return info.Size, true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return 0, false, nil
+	}
+	return 0, false, err
+}
+
+// PutObject uploads data to objectKey.
+func (s *MinioStore) PutObject(ctx context.Context, objectKey string, data []byte, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, objectKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// CopyObject copies srcKey to dstKey server-side within the bucket.
+func (s *MinioStore) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	_, err := s.Client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.Bucket, Object: dstKey},
+		minio.CopySrcOptions{Bucket: s.Bucket, Object: srcKey},
+	)
+	return err
+}
+
+// DeleteObject removes objectKey; removing a missing object is not an error.
+func (s *MinioStore) DeleteObject(ctx context.Context, objectKey string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, objectKey, minio.RemoveObjectOptions{})
+}
+
+// DeletePrefix removes every object under prefix and returns how many were deleted.
+func (s *MinioStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	deleted := 0
+	for obj := range s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return deleted, obj.Err
+		}
+		if err := s.Client.RemoveObject(ctx, s.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// MediaPrefix is the key prefix that holds the original and all derived
+// objects of a media. Each tenant has its own prefix; media without a tenant
+// (created before API keys) keeps the original flat layout.
+func MediaPrefix(tenantID, mediaID string) string {
+	if tenantID == "" {
+		return "media/" + mediaID + "/"
+	}
+	return "tenants/" + tenantID + "/media/" + mediaID + "/"
+}
+
 func normalizeEndpoint(raw string, fallbackSSL bool) (string, bool) {
 	endpoint := raw
 	useSSL := fallbackSSL
@@ -0,0 +1,59 @@
+package sweeper
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+	"sys-design/internal/obs"
+)
+
+// Sweeper hands RUNNING tasks whose worker stopped renewing the lease back
+// to the scheduler as retries, or fails them once MaxRetries is used up. It
+// also returns dispatched tasks nobody claimed within DispatchTimeout to the
+// scheduler, since their message was lost.
+type Sweeper struct {
+	DB              *pgxpool.Pool
+	Interval        time.Duration
+	BatchSize       int
+	MaxRetries      int
+	DispatchTimeout time.Duration
+}
+
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RunOnce(ctx); err != nil {
+				log.Printf("sweeper run failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Sweeper) RunOnce(ctx context.Context) error {
+	requeued, failed, err := db.RequeueExpiredTasks(ctx, s.DB, s.BatchSize, s.MaxRetries)
+	if requeued+failed > 0 {
+		log.Printf("sweeper requeued %d and failed %d tasks with expired leases", requeued, failed)
+		obs.TasksLeaseExpired.Add(float64(requeued + failed))
+		obs.TasksFailed.Add(float64(failed))
+	}
+	if err != nil {
+		return err
+	}
+
+	redispatched, err := db.RedispatchStaleTasks(ctx, s.DB, s.BatchSize, s.DispatchTimeout)
+	if redispatched > 0 {
+		log.Printf("sweeper returned %d unclaimed dispatched tasks to the scheduler", redispatched)
+		obs.TasksRedispatched.Add(float64(redispatched))
+	}
+	return err
+}
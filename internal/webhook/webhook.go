@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sys-design/internal/db"
+	"sys-design/internal/fetch"
+	"sys-design/internal/obs"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Id"
+)
+
+// Dispatcher delivers queued webhook events. Failed attempts are retried with
+// exponential backoff until MaxAttempts is reached.
+type Dispatcher struct {
+	DB          *pgxpool.Pool
+	Client      *http.Client
+	Secret      []byte
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Interval    time.Duration
+	BatchSize   int
+}
+
+// NewClient returns the client for webhook delivery. Like imports, it cannot
+// connect to private, loopback or link-local addresses outside allow, and it
+// does not follow redirects, which would otherwise carry the signed request
+// to a destination the receiver chose; a redirect counts as a failed attempt.
+func NewClient(timeout time.Duration, allow []*net.IPNet) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: fetch.NewTransport(timeout, allow),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.RunOnce(ctx); err != nil {
+			log.Printf("webhook dispatch failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	// Lease claimed rows for longer than a single attempt can take.
+	lease := d.Client.Timeout + 30*time.Second
+	deliveries, err := db.ClaimWebhookDeliveries(ctx, d.DB, d.BatchSize, lease)
+	if err != nil {
+		return err
+	}
+	for _, dl := range deliveries {
+		d.attempt(ctx, dl)
+	}
+	return nil
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, dl *db.WebhookDeliveryRow) {
+	code, err := d.send(ctx, dl)
+	if err == nil {
+		obs.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		if err := db.MarkWebhookDelivered(ctx, d.DB, dl.ID, *code); err != nil {
+			log.Printf("mark webhook %s delivered failed: %v", dl.ID, err)
+		}
+		return
+	}
+
+	log.Printf("webhook %s attempt %d to %s failed: %v", dl.ID, dl.Attempts+1, dl.URL, err)
+	after, retry := d.retryAfter(dl.Attempts)
+	if !retry {
+		obs.WebhookDeliveries.WithLabelValues("failed").Inc()
+		if err := db.MarkWebhookFailed(ctx, d.DB, dl.ID, code, err.Error()); err != nil {
+			log.Printf("mark webhook %s failed: %v", dl.ID, err)
+		}
+		return
+	}
+	obs.WebhookDeliveries.WithLabelValues("retry").Inc()
+	if err := db.MarkWebhookRetry(ctx, d.DB, dl.ID, code, err.Error(), after); err != nil {
+		log.Printf("mark webhook %s retry failed: %v", dl.ID, err)
+	}
+}
+
+// send POSTs the payload once. It returns the response status code, if one was
+// received, and an error for anything other than a 2xx response.
+func (d *Dispatcher) send(ctx context.Context, dl *db.WebhookDeliveryRow) (*int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(d.Secret, ts, dl.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	code := resp.StatusCode
+	if code < 200 || code > 299 {
+		return &code, fmt.Errorf("unexpected status %d", code)
+	}
+	return &code, nil
+}
+
+// retryAfter returns the backoff before the next attempt of a delivery
+// whose attempt number attempts+1 just failed, or false once MaxAttempts is
+// used up.
+func (d *Dispatcher) retryAfter(attempts int) (time.Duration, bool) {
+	if attempts+1 >= d.MaxAttempts {
+		return 0, false
+	}
+	b := d.BaseBackoff << attempts
+	if b <= 0 || b > d.MaxBackoff {
+		return d.MaxBackoff, true
+	}
+	return b, true
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it with the shared secret and should reject stale timestamps to
+// prevent replays.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
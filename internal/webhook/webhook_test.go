@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sys-design/internal/db"
+	"sys-design/internal/fetch"
+)
+
+var loopback = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
+func testDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      NewClient(5*time.Second, loopback),
+		Secret:      []byte("test-secret"),
+		MaxAttempts: 5,
+		BaseBackoff: 5 * time.Second,
+		MaxBackoff:  time.Minute,
+	}
+}
+
+func TestSendSignsPayload(t *testing.T) {
+	payload := []byte(`{"event":"media.ready","media_id":"m1"}`)
+	got := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts := r.Header.Get(TimestampHeader)
+		if want := "sha256=" + Sign([]byte("test-secret"), ts, body); r.Header.Get(SignatureHeader) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		got <- r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	code, err := testDispatcher().send(context.Background(), &db.WebhookDeliveryRow{ID: "d1", URL: srv.URL, Payload: payload})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if code == nil || *code != http.StatusNoContent {
+		t.Fatalf("code = %v, want 204", code)
+	}
+	r := <-got
+	if r.Header.Get(DeliveryHeader) != "d1" {
+		t.Errorf("%s = %q, want d1", DeliveryHeader, r.Header.Get(DeliveryHeader))
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("%s = %q, want the current unix time", TimestampHeader, r.Header.Get(TimestampHeader))
+	}
+}
+
+func TestSendFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	code, err := testDispatcher().send(context.Background(), &db.WebhookDeliveryRow{ID: "d1", URL: srv.URL, Payload: []byte("{}")})
+	if err == nil {
+		t.Fatal("send succeeded on 503")
+	}
+	if code == nil || *code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %v, want 503", code)
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	code, err := testDispatcher().send(context.Background(), &db.WebhookDeliveryRow{ID: "d1", URL: srv.URL, Payload: []byte("{}")})
+	if err == nil {
+		t.Fatal("send succeeded on a redirect")
+	}
+	if code == nil || *code != http.StatusTemporaryRedirect {
+		t.Fatalf("code = %v, want 307", code)
+	}
+	if followed.Load() {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestSendBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback receiver")
+	}))
+	defer srv.Close()
+
+	d := testDispatcher()
+	d.Client = NewClient(5*time.Second, nil)
+	_, err := d.send(context.Background(), &db.WebhookDeliveryRow{ID: "d1", URL: srv.URL, Payload: []byte("{}")})
+	if !errors.Is(err, fetch.ErrPermanent) {
+		t.Fatalf("err = %v, want a blocked destination", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	d := testDispatcher()
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+		retry    bool
+	}{
+		{0, 5 * time.Second, true},
+		{1, 10 * time.Second, true},
+		{2, 20 * time.Second, true},
+		{3, 40 * time.Second, true},
+		{4, 0, false},
+	} {
+		got, retry := d.retryAfter(tc.attempts)
+		if got != tc.want || retry != tc.retry {
+			t.Errorf("retryAfter(%d) = %v, %v; want %v, %v", tc.attempts, got, retry, tc.want, tc.retry)
+		}
+	}
+
+	d.MaxAttempts = 100
+	if got, _ := d.retryAfter(4); got != time.Minute {
+		t.Errorf("retryAfter(4) = %v, want capped at %v", got, time.Minute)
+	}
+	if got, _ := d.retryAfter(70); got != time.Minute {
+		t.Errorf("retryAfter(70) = %v, want %v after overflow", got, time.Minute)
+	}
+}
@@ -7,12 +7,14 @@ if [ $# -lt 1 ]; then
 fi
 
 FILE="$1"
+: "${API_KEY:?set API_KEY (go run ./cmd/apikey -owner <owner>)}"
 if [ ! -f "$FILE" ]; then
   echo "File not found: $FILE"
   exit 1
 fi
 
 RESP=$(curl -s -X POST http://localhost:8080/upload-url \
+  -H "Authorization: Bearer $API_KEY" \
   -H "Content-Type: application/json" \
   -d '{"content_type":"image/jpeg","file_name":"test.jpg"}')
 
@@ -0,0 +1,49 @@
+#!/usr/bin/env python3
+"""Local stand-in webhook receiver.
+
+Verifies the X-Webhook-Signature header and prints each delivery.
+Set FAIL_FIRST=N to answer the first N requests with 500 and exercise retries.
+
+Usage: WEBHOOK_SECRET=dev-webhook-secret ./scripts/webhook_receiver.py [port]
+"""
+import hashlib
+import hmac
+import json
+import os
+import sys
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+SECRET = os.environ.get("WEBHOOK_SECRET", "dev-webhook-secret").encode()
+FAIL_FIRST = int(os.environ.get("FAIL_FIRST", "0"))
+seen = 0
+
+
+class Handler(BaseHTTPRequestHandler):
+    def do_POST(self):
+        global seen
+        seen += 1
+        body = self.rfile.read(int(self.headers.get("Content-Length", 0)))
+        ts = self.headers.get("X-Webhook-Timestamp", "")
+        sig = self.headers.get("X-Webhook-Signature", "")
+        want = "sha256=" + hmac.new(SECRET, ts.encode() + b"." + body, hashlib.sha256).hexdigest()
+        valid = hmac.compare_digest(sig, want)
+
+        print(f"#{seen} id={self.headers.get('X-Webhook-Id')} valid_signature={valid}")
+        print(json.dumps(json.loads(body or b"{}"), indent=2))
+
+        if not valid:
+            self.send_response(401)
+        elif seen <= FAIL_FIRST:
+            self.send_response(500)
+        else:
+            self.send_response(204)
+        self.end_headers()
+
+    def log_message(self, *args):
+        pass
+
+
+if __name__ == "__main__":
+    port = int(sys.argv[1]) if len(sys.argv) > 1 else 9099
+    print(f"listening on :{port}")
+    HTTPServer(("0.0.0.0", port), Handler).serve_forever()
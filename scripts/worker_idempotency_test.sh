@@ -9,10 +9,11 @@ fi
 MEDIA_ID="$1"
 NETWORK="${SYS_DESIGN_NETWORK:-sys-design_default}"
 
-# 1) Get task_id and output_key
-TASK_INFO=$(docker compose exec -T postgres psql -U app -d app -c "select id, output_key from processing_task where media_id='${MEDIA_ID}' order by created_at desc limit 1;")
+# 1) Get task_id, step and output_key
+TASK_INFO=$(docker compose exec -T postgres psql -U app -d app -c "select id, step, output_key from processing_task where media_id='${MEDIA_ID}' order by created_at desc limit 1;")
 TASK_ID=$(echo "$TASK_INFO" | awk 'NR==3 {print $1}')
-OUTPUT_KEY=$(echo "$TASK_INFO" | awk 'NR==3 {print $3}')
+STEP=$(echo "$TASK_INFO" | awk 'NR==3 {print $3}')
+OUTPUT_KEY=$(echo "$TASK_INFO" | awk 'NR==3 {print $5}')
 
 if [ -z "$TASK_ID" ] || [ -z "$OUTPUT_KEY" ]; then
   echo "No task found for media_id: $MEDIA_ID"
@@ -29,10 +30,10 @@ docker run --rm --network "$NETWORK" \
 # 3) Publish message with same task_id
 PUBLISH_BODY=$(python3 - <<PY
 import json
-task = {"task_id": "${TASK_ID}", "media_id": "${MEDIA_ID}", "step": "resize"}
+task = {"schema_version": "1.0", "task_id": "${TASK_ID}", "media_id": "${MEDIA_ID}", "step": "${STEP}"}
 body = {
   "properties": {},
-  "routing_key": "processing_tasks",
+  "routing_key": "task.${STEP}",
   "payload": json.dumps(task),
   "payload_encoding": "string",
 }
@@ -41,7 +42,7 @@ PY
 )
 
 curl -s -u guest:guest -H "Content-Type: application/json" \
-  -X POST http://localhost:15672/api/exchanges/%2F/amq.default/publish \
+  -X POST http://localhost:15672/api/exchanges/%2F/processing/publish \
   -d "$PUBLISH_BODY"
 
 sleep 1